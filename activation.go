@@ -0,0 +1,71 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "time"
+
+// WithDeferredActivation keeps a stream created outside the opening hours from connecting. It stays in
+// State_waiting_for_open and connects once the exchange opens, so the same code can be started at any hour. A
+// MarketOpenedEvent is published on the event bus before connecting, see WithEventBus. Subscribe returns
+// ErrNotConnected until then, the instruments are subscribed on activation.
+func WithDeferredActivation() Option {
+	return func(lms *stream) {
+		lms.deferActivation = true
+	}
+}
+
+// start connects right away or waits for the exchange to open with deferred activation
+func (lms *stream) start() {
+	if lms.deferActivation {
+		if now := time.Now(); !nextOpening(now).Equal(now) {
+			lms.setState(State_waiting_for_open)
+
+			lms.workers.Add(1)
+			go lms.activateAtOpen()
+			return
+		}
+	}
+
+	lms.setState(State_connecting)
+	lms.connect()
+}
+
+func (lms *stream) activateAtOpen() {
+	defer lms.workers.Done()
+	defer lms.running("activation")()
+
+	opening := nextOpening(time.Now())
+
+	select {
+	case <-lms.done:
+		return
+
+	case <-time.After(time.Until(opening)):
+	}
+
+	lms.publish(&MarketOpenedEvent{EventHeader: EventHeader{Time: time.Now()}, Session: sessionAt(opening.Add(time.Second))})
+	lms.setState(State_connecting)
+	lms.connect()
+}
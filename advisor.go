@@ -0,0 +1,131 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// lemonTolerance is the time after which lemon.markets may close a stream whose receiver does not fetch
+const lemonTolerance = time.Second * 10
+
+// rateTracker keeps the average and the peak rate of updates per second
+type rateTracker struct {
+	first   time.Time
+	second  int64
+	current uint64
+	peak    uint64
+	total   uint64
+	mutex   *sync.Mutex
+}
+
+func (tracker *rateTracker) observe(now time.Time) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if tracker.total == 0 {
+		tracker.first = now
+	}
+
+	if second := now.Unix(); second != tracker.second {
+		tracker.second = second
+		tracker.current = 0
+	}
+
+	tracker.current++
+	tracker.total++
+
+	if tracker.current > tracker.peak {
+		tracker.peak = tracker.current
+	}
+}
+
+func (tracker *rateTracker) rates(now time.Time) (average, peak float64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if tracker.total == 0 {
+		return 0, 0
+	}
+
+	elapsed := now.Sub(tracker.first).Seconds()
+
+	if elapsed < 1 {
+		elapsed = 1
+	}
+
+	return float64(tracker.total) / elapsed, float64(tracker.peak)
+}
+
+// BufferAdvice is the result of AdviseBufferSize
+type BufferAdvice struct {
+	AverageRate float64  // Average updates per second since the first update
+	PeakRate    float64  // Highest number of updates within one second
+	Capacity    int      // Capacity of the current update channel plus the delivery queue
+	Recommended int      // Recommended capacity of the update channel and the delivery queue together
+	Warnings    []string // Human readable warnings
+}
+
+// AdviseBufferSize recommends a capacity for the update channel based on the observed update rates. maxPause is the
+// longest time your receiver may not fetch from the channel, e.g. during a slow database write. The recommendation
+// covers the peak rate for that time. A delivery queue, e.g. from WithRingBuffer, buffers in front of the channel and
+// counts towards the capacity. The advice is only as good as the observed traffic, so ask again after the
+// stream ran through a busy period.
+func (lms *stream) AdviseBufferSize(maxPause time.Duration) *BufferAdvice {
+	average, peak := lms.rate.rates(time.Now())
+	_, capacity := lms.updateQueue()
+
+	if lms.delivery != nil {
+		_, queued := lms.delivery.length()
+		capacity += queued
+	}
+
+	advice := &BufferAdvice{
+		AverageRate: average,
+		PeakRate:    peak,
+		Capacity:    capacity,
+		Recommended: int(math.Ceil(peak * maxPause.Seconds())),
+		Warnings:    make([]string, 0)}
+
+	if peak == 0 {
+		advice.Warnings = append(advice.Warnings, "No updates observed yet")
+	}
+
+	if maxPause >= lemonTolerance {
+		advice.Warnings = append(advice.Warnings,
+			fmt.Sprintf("Pauses of %s exceed the lemon.markets tolerance of %s. The stream may get closed", maxPause,
+				lemonTolerance))
+	}
+
+	if capacity < advice.Recommended {
+		advice.Warnings = append(advice.Warnings,
+			fmt.Sprintf("Buffer capacity %d is below the recommended %d", capacity, advice.Recommended))
+	}
+
+	return advice
+}
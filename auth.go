@@ -0,0 +1,214 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// authTimeout limits the time an AuthProvider may take to return a token
+const authTimeout = time.Second * 30
+
+// ErrNoToken is returned when an AuthProvider could not find a token
+var ErrNoToken error = errors.New("No API token available")
+
+// AuthProvider returns the API token to connect with. It's consulted before every connect, so implementations can
+// rotate tokens.
+type AuthProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is an AuthProvider returning always the same token
+type StaticToken string
+
+// Token returns the token
+func (token StaticToken) Token(ctx context.Context) (string, error) {
+	if token == "" {
+		return "", ErrNoToken
+	}
+
+	return string(token), nil
+}
+
+// EnvToken is an AuthProvider reading the token from the environment variable with this name
+type EnvToken string
+
+// Token returns the value of the environment variable
+func (name EnvToken) Token(ctx context.Context) (string, error) {
+	if token := os.Getenv(string(name)); token != "" {
+		return token, nil
+	}
+
+	return "", fmt.Errorf("%w: environment variable %s is empty", ErrNoToken, string(name))
+}
+
+// FileToken is an AuthProvider reading the token from the file with this path. The file is read on every connect, so
+// the token can be rotated by replacing the file. Surrounding whitespace is trimmed.
+type FileToken string
+
+// Token returns the content of the file
+func (path FileToken) Token(ctx context.Context) (string, error) {
+	content, err := ioutil.ReadFile(string(path))
+
+	if err != nil {
+		return "", err
+	}
+
+	if token := strings.TrimSpace(string(content)); token != "" {
+		return token, nil
+	}
+
+	return "", fmt.Errorf("%w: file %s is empty", ErrNoToken, string(path))
+}
+
+// ClientCredentials is an AuthProvider implementing the OAuth 2.0 client credentials flow. Tokens are cached until
+// shortly before they expire.
+type ClientCredentials struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+	token        string
+	expiry       time.Time
+	mutex        *sync.Mutex
+}
+
+// NewClientCredentials creates a client credentials provider fetching tokens from the token URL
+func NewClientCredentials(tokenURL, clientID, clientSecret string, scopes ...string) *ClientCredentials {
+	return &ClientCredentials{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: authTimeout},
+		mutex:        &sync.Mutex{}}
+}
+
+// Token returns the cached token or fetches a new one
+func (credentials *ClientCredentials) Token(ctx context.Context) (string, error) {
+	credentials.mutex.Lock()
+	defer credentials.mutex.Unlock()
+
+	if credentials.token != "" && time.Now().Before(credentials.expiry) {
+		return credentials.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {credentials.clientID},
+		"client_secret": {credentials.clientSecret}}
+
+	if len(credentials.scopes) > 0 {
+		form.Set("scope", strings.Join(credentials.scopes, " "))
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, credentials.tokenURL,
+		strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return "", err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := credentials.client.Do(request)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token endpoint returned %s", ErrNoToken, response.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("%w: token endpoint returned no access token", ErrNoToken)
+	}
+
+	credentials.token = body.AccessToken
+	// Refresh a minute early so a token never expires during the handshake
+	credentials.expiry = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - time.Minute)
+
+	return credentials.token, nil
+}
+
+// WithAuthProvider sends the token of the provider as bearer token in the Authorization header of every handshake
+func WithAuthProvider(provider AuthProvider) Option {
+	return func(lms *stream) {
+		lms.authProvider = provider
+	}
+}
+
+// WithAPIToken sends the token as bearer token in the Authorization header of every handshake. It's a shorthand for
+// WithAuthProvider(StaticToken(token)).
+func WithAPIToken(token string) Option {
+	return WithAuthProvider(StaticToken(token))
+}
+
+// dial connects to the endpoint with the configured headers and authentication
+func (lms *stream) dial(endpoint string) (*websocket.Conn, error) {
+	header := lms.header.Clone()
+
+	if lms.authProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+		defer cancel()
+
+		token, err := lms.authProvider.Token(ctx)
+
+		if err != nil {
+			lms.reportError(err)
+			return nil, err
+		}
+
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	connection, _, err := lms.dialer.Dial(endpoint, header)
+
+	return connection, err
+}
@@ -0,0 +1,123 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"sync"
+	"time"
+)
+
+// IndexUpdate is a synthetic index price computed by a Basket.
+type IndexUpdate struct {
+	Value float64   // Weighted sum of the constituent prices
+	Time  time.Time // Time the index was recalculated
+	Stale []string  // ISINs whose last price is older than the configured stale duration
+}
+
+type basketPrice struct {
+	price   float64
+	updated time.Time
+}
+
+// Basket is a weighted set of instruments which is turned into a single synthetic index price. Feed it ticks via
+// Update or Run. The index value is the sum of weight * last price over all constituents.
+type Basket struct {
+	weights    map[string]float64
+	prices     map[string]basketPrice
+	staleAfter time.Duration
+	mutex      *sync.Mutex
+}
+
+// NewBasket creates a basket from a map of ISIN to weight. Constituents without a price update for longer than
+// staleAfter are reported as stale but still contribute their last known price. A staleAfter of 0 disables staleness
+// tracking.
+func NewBasket(weights map[string]float64, staleAfter time.Duration) *Basket {
+	basket := &Basket{
+		weights:    make(map[string]float64),
+		prices:     make(map[string]basketPrice),
+		staleAfter: staleAfter,
+		mutex:      &sync.Mutex{}}
+
+	for isin, weight := range weights {
+		basket.weights[isin] = weight
+	}
+
+	return basket
+}
+
+// GetConstituents returns all ISINs of the basket
+func (basket *Basket) GetConstituents() []string {
+	constituents := make([]string, 0, len(basket.weights))
+
+	for isin := range basket.weights {
+		constituents = append(constituents, isin)
+	}
+
+	return constituents
+}
+
+// Update feeds a tick into the basket. The second return value is false if the tick does not belong to the basket or
+// not every constituent has received a price yet.
+func (basket *Basket) Update(tick *Tick) (*IndexUpdate, bool) {
+	return basket.update(tick, time.Now())
+}
+
+func (basket *Basket) update(tick *Tick, now time.Time) (*IndexUpdate, bool) {
+	basket.mutex.Lock()
+	defer basket.mutex.Unlock()
+
+	if _, exists := basket.weights[tick.ISIN]; !exists {
+		return nil, false
+	}
+
+	basket.prices[tick.ISIN] = basketPrice{price: tick.Price, updated: now}
+
+	if len(basket.prices) < len(basket.weights) {
+		return nil, false
+	}
+
+	update := &IndexUpdate{Time: now, Stale: make([]string, 0)}
+
+	for isin, weight := range basket.weights {
+		price := basket.prices[isin]
+		update.Value += weight * price.price
+
+		if basket.staleAfter > 0 && now.Sub(price.updated) > basket.staleAfter {
+			update.Stale = append(update.Stale, isin)
+		}
+	}
+
+	return update, true
+}
+
+// Run reads ticks until the tick channel is closed and sends every recalculated index value into the update channel.
+// Keep in mind: You are responsible for both channels.
+func (basket *Basket) Run(ticks <-chan *Tick, updates chan<- *IndexUpdate) {
+	for tick := range ticks {
+		if update, ok := basket.Update(tick); ok {
+			updates <- update
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "time"
+
+// SubscribeAll subscribes to several instruments and returns the result per ISIN. A nil error means the subscription
+// was sent or already existed. ErrNotConnected means the ISIN is registered and will be subscribed after the next
+// reconnect. A SubscriptionError wrapping ErrQuotaExceeded means the ISIN was not registered. See WithBatchThrottle to
+// slow down large batches.
+func (lms *stream) SubscribeAll(isins []string) map[string]error {
+	return lms.batch(isins, lms.subscribe)
+}
+
+// UnsubscribeAll unsubscribes from several instruments and returns the result per ISIN. A nil error means the
+// unsubscription was sent or the ISIN was not subscribed. See WithBatchThrottle to slow down large batches.
+func (lms *stream) UnsubscribeAll(isins []string) map[string]error {
+	return lms.batch(isins, lms.unsubscribe)
+}
+
+func (lms *stream) batch(isins []string, operation func(string) error) map[string]error {
+	results := make(map[string]error, len(isins))
+
+	for i, isin := range isins {
+		if _, done := results[isin]; done {
+			continue
+		}
+
+		if i > 0 && lms.batchThrottle > 0 {
+			select {
+			case <-time.After(lms.batchThrottle):
+
+			case <-lms.done:
+				results[isin] = ErrConnectionClosed
+				continue
+			}
+		}
+
+		results[isin] = operation(isin)
+	}
+
+	return results
+}
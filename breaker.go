@@ -0,0 +1,148 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type breakerState struct {
+	last          float64   // Last accepted price
+	lastTime      time.Time // Time of the last accepted price
+	tripped       bool      // Delivery is paused
+	suspect       float64   // Price which tripped the breaker or the latest deviating price
+	confirmations int       // Prints confirming the suspect price
+}
+
+// circuitBreaker withholds prices which move more than a percentage within a time window until subsequent prints
+// confirm the new level
+type circuitBreaker struct {
+	percent       float64
+	window        time.Duration
+	confirmations int
+	states        map[string]*breakerState
+	mutex         *sync.Mutex
+}
+
+func newCircuitBreaker(percent float64, window time.Duration, confirmations int) *circuitBreaker {
+	return &circuitBreaker{
+		percent:       percent,
+		window:        window,
+		confirmations: confirmations,
+		states:        make(map[string]*breakerState),
+		mutex:         &sync.Mutex{}}
+}
+
+func (breaker *circuitBreaker) near(price, reference float64) bool {
+	return reference != 0 && math.Abs(price/reference-1)*100 <= breaker.percent
+}
+
+// check returns whether the price may be delivered and whether this price tripped the breaker
+func (breaker *circuitBreaker) check(isin string, price float64, now time.Time) (deliver bool, tripped bool) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	state, exists := breaker.states[isin]
+
+	if !exists {
+		breaker.states[isin] = &breakerState{last: price, lastTime: now}
+		return true, false
+	}
+
+	accept := func() (bool, bool) {
+		state.tripped = false
+		state.last = price
+		state.lastTime = now
+		return true, false
+	}
+
+	if !state.tripped {
+		// A zero price, e.g. the mid of a one-sided quote, is no reference to compare against
+		if state.last == 0 || now.Sub(state.lastTime) > breaker.window || breaker.near(price, state.last) {
+			return accept()
+		}
+
+		state.tripped = true
+		state.suspect = price
+		state.confirmations = 0
+		return false, true
+	}
+
+	if breaker.near(price, state.suspect) {
+		state.confirmations++
+
+		if state.confirmations >= breaker.confirmations {
+			return accept()
+		}
+	} else if breaker.near(price, state.last) {
+		// Back at the old level: The suspect price was a bad tick
+		return accept()
+	} else {
+		state.suspect = price
+		state.confirmations = 0
+	}
+
+	return false, false
+}
+
+// SetCircuitBreaker pauses the delivery of an instrument when its price moves more than percent within window. The
+// pause ends once confirmations subsequent prints confirm the new level or the price returns to the old level.
+// Withheld updates are counted as drops. Tripping sends a SubscriptionError wrapping ErrCircuitBreakerTripped into the
+// error channel. Ticks are checked by price and quotes by mid price. Set percent to 0 to disable it.
+func (lms *stream) SetCircuitBreaker(percent float64, window time.Duration, confirmations int) {
+	if percent <= 0 {
+		lms.breaker = nil
+	} else {
+		lms.breaker = newCircuitBreaker(percent, window, confirmations)
+	}
+}
+
+func (lms *stream) passesCircuitBreaker(isin string, update interface{}) bool {
+	breaker := lms.breaker
+
+	if breaker == nil {
+		return true
+	}
+
+	var price float64
+
+	switch typed := update.(type) {
+	case *Tick:
+		price = typed.Price
+
+	case *Quote:
+		price = (typed.Bid + typed.Ask) / 2
+	}
+
+	deliver, tripped := breaker.check(isin, price, time.Now())
+
+	if tripped {
+		lms.reportError(&SubscriptionError{ISIN: isin, Err: ErrCircuitBreakerTripped})
+	}
+
+	return deliver
+}
@@ -0,0 +1,162 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakoutType describes which kind of breakout happened
+type BreakoutType string
+
+const (
+	// A new session high was made
+	Breakout_session_high BreakoutType = "session high"
+
+	// A new session low was made
+	Breakout_session_low BreakoutType = "session low"
+
+	// The price crossed a user defined level from below
+	Breakout_level_up BreakoutType = "level crossed up"
+
+	// The price crossed a user defined level from above
+	Breakout_level_down BreakoutType = "level crossed down"
+)
+
+// BreakoutEvent is emitted by a BreakoutDetector
+type BreakoutEvent struct {
+	Type     BreakoutType // Kind of the breakout
+	ISIN     string       // ISIN of the instrument
+	Price    float64      // Price which caused the breakout
+	Previous float64      // Previous session high/low or the crossed level
+	Time     time.Time    // Time of the tick
+}
+
+type sessionRange struct {
+	day       int
+	high, low float64
+	last      float64
+}
+
+// BreakoutDetector tracks the session high and low of every instrument it sees and emits events on new extremes or
+// when user defined levels are crossed. A session is a calendar day in Europe/Berlin.
+type BreakoutDetector struct {
+	sessions map[string]*sessionRange
+	levels   map[string][]float64
+	mutex    *sync.Mutex
+}
+
+// NewBreakoutDetector creates an empty breakout detector
+func NewBreakoutDetector() *BreakoutDetector {
+	return &BreakoutDetector{
+		sessions: make(map[string]*sessionRange),
+		levels:   make(map[string][]float64),
+		mutex:    &sync.Mutex{}}
+}
+
+// AddLevel registers a price level for an instrument. Crossing it in either direction emits an event.
+func (detector *BreakoutDetector) AddLevel(isin string, level float64) {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+
+	detector.levels[isin] = append(detector.levels[isin], level)
+}
+
+// RemoveLevels removes all levels of an instrument
+func (detector *BreakoutDetector) RemoveLevels(isin string) {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+
+	delete(detector.levels, isin)
+}
+
+// Update feeds a tick into the detector and returns all breakouts it caused. The first tick of a session only opens
+// the range and never emits a session high/low event. Levels don't depend on the session, so a price gapping through a
+// level overnight emits a level event. A price exactly at a level counts as above it.
+func (detector *BreakoutDetector) Update(tick *Tick) []*BreakoutEvent {
+	return detector.update(tick, time.Now())
+}
+
+func (detector *BreakoutDetector) update(tick *Tick, now time.Time) []*BreakoutEvent {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+
+	local := now.In(berlin())
+	day := local.Year()*1000 + local.YearDay()
+	events := make([]*BreakoutEvent, 0)
+
+	newEvent := func(breakoutType BreakoutType, previous float64) {
+		events = append(events, &BreakoutEvent{
+			Type:     breakoutType,
+			ISIN:     tick.ISIN,
+			Price:    tick.Price,
+			Previous: previous,
+			Time:     now})
+	}
+
+	session, exists := detector.sessions[tick.ISIN]
+
+	if !exists {
+		detector.sessions[tick.ISIN] = &sessionRange{day: day, high: tick.Price, low: tick.Price, last: tick.Price}
+		return events
+	}
+
+	previous := session.last
+
+	if session.day != day {
+		session.day = day
+		session.high = tick.Price
+		session.low = tick.Price
+	} else if tick.Price > session.high {
+		newEvent(Breakout_session_high, session.high)
+		session.high = tick.Price
+	} else if tick.Price < session.low {
+		newEvent(Breakout_session_low, session.low)
+		session.low = tick.Price
+	}
+
+	for _, level := range detector.levels[tick.ISIN] {
+		if previous < level && tick.Price >= level {
+			newEvent(Breakout_level_up, level)
+		} else if previous >= level && tick.Price < level {
+			newEvent(Breakout_level_down, level)
+		}
+	}
+
+	session.last = tick.Price
+
+	return events
+}
+
+// Run reads ticks until the tick channel is closed and sends every breakout into the event channel. Keep in mind:
+// You are responsible for both channels.
+func (detector *BreakoutDetector) Run(ticks <-chan *Tick, events chan<- *BreakoutEvent) {
+	for tick := range ticks {
+		for _, event := range detector.Update(tick) {
+			events <- event
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+// isinChannelBuffer is the capacity of the channels returned by Channel
+const isinChannelBuffer = 100
+
+// Channel returns a dedicated channel for the ticks of one instrument. These ticks are no longer routed or sent into the
+// update channel. Calling it again for the same ISIN returns the same channel. The channel is owned by the library and
+// closed by Disconnect. Read from it, otherwise the stream blocks like on a full update channel. The instrument still
+// has to be subscribed.
+func (stream *TickStream) Channel(isin string) <-chan *Tick {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	if channel, exists := stream.isinChannels[isin]; exists {
+		return channel
+	}
+
+	channel := make(chan *Tick, isinChannelBuffer)
+
+	if stream.isClosing() {
+		close(channel)
+		return channel
+	}
+
+	if stream.isinChannels == nil {
+		stream.isinChannels = make(map[string]chan *Tick)
+	}
+
+	stream.isinChannels[isin] = channel
+
+	return channel
+}
+
+func (stream *TickStream) closeISINChannels() {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	for isin, channel := range stream.isinChannels {
+		close(channel)
+		delete(stream.isinChannels, isin)
+	}
+}
+
+// Channel returns a dedicated channel for the quotes of one instrument. These quotes are no longer routed or sent into
+// the update channel. Calling it again for the same ISIN returns the same channel. The channel is owned by the library
+// and closed by Disconnect. Read from it, otherwise the stream blocks like on a full update channel. The instrument
+// still has to be subscribed.
+func (stream *QuoteStream) Channel(isin string) <-chan *Quote {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	if channel, exists := stream.isinChannels[isin]; exists {
+		return channel
+	}
+
+	channel := make(chan *Quote, isinChannelBuffer)
+
+	if stream.isClosing() {
+		close(channel)
+		return channel
+	}
+
+	if stream.isinChannels == nil {
+		stream.isinChannels = make(map[string]chan *Quote)
+	}
+
+	stream.isinChannels[isin] = channel
+
+	return channel
+}
+
+func (stream *QuoteStream) closeISINChannels() {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	for isin, channel := range stream.isinChannels {
+		close(channel)
+		delete(stream.isinChannels, isin)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SubscribeAndWait subscribes to an instrument and blocks until the first update for it arrives. lemon.markets does
+// not acknowledge subscriptions, so the first update is the only sign that the subscription is live. Returns the
+// context's error if it expires first, ErrUnsubscribed if the instrument gets unsubscribed before its first update or
+// ErrConnectionClosed if the stream gets disconnected while waiting. A rejection by the quota is returned right away.
+func (lms *stream) SubscribeAndWait(ctx context.Context, isin string) error {
+	return lms.waitSubscribed(ctx, isin, lms.Subscribe)
+}
+
+// waitSubscribed subscribes with the given function and waits for the first update, see SubscribeAndWait
+func (lms *stream) waitSubscribed(ctx context.Context, isin string, subscribe func(string) error) error {
+	waiter := lms.addWaiter(isin)
+
+	if err := subscribe(isin); errors.Is(err, ErrQuotaExceeded) {
+		lms.removeWaiter(isin, waiter)
+		return err
+	}
+
+	select {
+	case <-waiter:
+		if !lms.isConfirmed(isin) {
+			return ErrUnsubscribed
+		}
+
+		return nil
+
+	case <-ctx.Done():
+		lms.removeWaiter(isin, waiter)
+		return ctx.Err()
+
+	case <-lms.done:
+		return ErrConnectionClosed
+	}
+}
+
+// addWaiter returns a channel which is closed as soon as the ISIN is confirmed. If it already is the returned channel
+// is closed.
+func (lms *stream) addWaiter(isin string) <-chan struct{} {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	waiter := make(chan struct{})
+
+	if lms.confirmed[isin] {
+		close(waiter)
+	} else {
+		lms.waiters[isin] = append(lms.waiters[isin], waiter)
+	}
+
+	return waiter
+}
+
+// removeWaiter forgets a waiter which gave up
+func (lms *stream) removeWaiter(isin string, waiter <-chan struct{}) {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	waiters := lms.waiters[isin]
+
+	for i, existing := range waiters {
+		if existing == waiter {
+			waiters = append(waiters[:i:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) == 0 {
+		delete(lms.waiters, isin)
+	} else {
+		lms.waiters[isin] = waiters
+	}
+}
+
+// releaseWaiters wakes up everyone waiting for the ISIN. The caller must hold subscriptionsMutex
+func (lms *stream) releaseWaiters(isin string) {
+	for _, waiter := range lms.waiters[isin] {
+		close(waiter)
+	}
+
+	delete(lms.waiters, isin)
+}
+
+// isConfirmed tells if the ISIN produced an update since it was subscribed
+func (lms *stream) isConfirmed(isin string) bool {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	return lms.confirmed[isin]
+}
+
+// confirm marks a subscribed ISIN as live and wakes up everyone waiting for it
+func (lms *stream) confirm(isin string) {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	if _, subscribed := lms.subscriptions[isin]; !subscribed || lms.confirmed[isin] {
+		return
+	}
+
+	lms.confirmed[isin] = true
+	lms.trace(isin, "first update received, subscription is live")
+	lms.releaseWaiters(isin)
+}
+
+// WaitReady blocks until the stream is connected and at least minISINs subscriptions produced an update. If there are
+// fewer subscriptions than minISINs all of them must be confirmed. Returns the context's error if it expires first or
+// ErrConnectionClosed if the stream gets disconnected while waiting.
+func (lms *stream) WaitReady(ctx context.Context, minISINs int) error {
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for !lms.isReady(minISINs) {
+		select {
+		case <-ticker.C:
+
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-lms.done:
+			return ErrConnectionClosed
+		}
+	}
+
+	return nil
+}
+
+func (lms *stream) isReady(minISINs int) bool {
+	if lms.GetState() != State_connected {
+		return false
+	}
+
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	if minISINs > len(lms.subscriptions) {
+		minISINs = len(lms.subscriptions)
+	}
+
+	return len(lms.confirmed) >= minISINs
+}
+
+// SubscriptionError is an error concerning a single subscription
+type SubscriptionError struct {
+	ISIN string // ISIN of the affected subscription
+	Err  error  // The underlying error
+}
+
+func (err *SubscriptionError) Error() string {
+	return err.Err.Error() + ": " + err.ISIN
+}
+
+// Unwrap returns the underlying error
+func (err *SubscriptionError) Unwrap() error {
+	return err.Err
+}
+
+// exchangeOpen tells verifySubscriptions whether silent subscriptions are suspicious. Replaced in tests
+var exchangeOpen = IsExchangeOpen
+
+// SetResubscribeVerification enables the verification of subscriptions after a reconnect. Every subscription which
+// did not produce an update within timeout while the exchange is open gets sent again. If it stays silent for another
+// timeout a SubscriptionError wrapping ErrSubscriptionNotLive is sent into the error channel. 0 disables it.
+func (lms *stream) SetResubscribeVerification(timeout time.Duration) {
+	lms.verifyTimeout = timeout
+}
+
+// verifySubscriptions resends and finally flags subscriptions which are not confirmed after a reconnect
+func (lms *stream) verifySubscriptions(timeout time.Duration) {
+	defer lms.workers.Done()
+	defer lms.running("subscription verifier")()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		select {
+		case <-time.After(timeout):
+
+		case <-lms.done:
+			return
+		}
+
+		if !exchangeOpen() {
+			return
+		}
+
+		for _, isin := range lms.unconfirmedISINs() {
+			if attempt == 0 {
+				lms.subscriptionsMutex.Lock()
+				if _, subscribed := lms.subscriptions[isin]; subscribed {
+					lms.sendSubscription(lms.getSubscription(isin))
+				}
+				lms.subscriptionsMutex.Unlock()
+			} else {
+				lms.reportError(&SubscriptionError{ISIN: isin, Err: ErrSubscriptionNotLive})
+			}
+		}
+	}
+}
+
+func (lms *stream) unconfirmedISINs() []string {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	unconfirmed := make([]string, 0)
+
+	for isin := range lms.subscriptions {
+		if !lms.confirmed[isin] {
+			unconfirmed = append(unconfirmed, isin)
+		}
+	}
+
+	return unconfirmed
+}
@@ -0,0 +1,66 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "time"
+
+// ConsistencyViolation is a tick whose price lies outside of the prevailing quote
+type ConsistencyViolation struct {
+	Tick  *Tick  // The offending tick
+	Quote *Quote // The prevailing quote at the time of the tick
+}
+
+// SetConsistencyChannel enables the cross-check of ticks against the prevailing quote of the quote source set via
+// SetQuoteSource. A tick with a price below bid or above ask by more than tolerancePercent is sent into the channel.
+// The tick itself is still delivered. Keep in mind that you are the one in charge of maintaining and servicing the
+// channel. Pass nil to disable the check.
+func (stream *TickStream) SetConsistencyChannel(channel chan<- *ConsistencyViolation, tolerancePercent float64) {
+	stream.consistencyPercent = tolerancePercent
+	stream.consistency = channel
+}
+
+func (lms *stream) checkConsistency(tick *Tick, quote *Quote) {
+	if lms.consistency == nil || !isInconsistent(tick, quote, lms.widen(tick.Session, lms.consistencyPercent)) {
+		return
+	}
+
+	// The cached quote is shared with the quote stream
+	prevailing := *quote
+	violation := &ConsistencyViolation{Tick: tick, Quote: &prevailing}
+	lms.publish(&ConsistencyEvent{EventHeader: EventHeader{Time: time.Now()}, Violation: violation})
+
+	select {
+	case lms.consistency <- violation:
+
+	case <-lms.done:
+	}
+}
+
+func isInconsistent(tick *Tick, quote *Quote, tolerancePercent float64) bool {
+	lower := quote.Bid * (1 - tolerancePercent/100)
+	upper := quote.Ask * (1 + tolerancePercent/100)
+
+	return tick.Price < lower || tick.Price > upper
+}
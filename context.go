@@ -0,0 +1,67 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "context"
+
+// NewTickStreamWithContext works like NewTickStream, but the stream is disconnected as soon as the context is done.
+// This stops the reconnect watchdog and all other background goroutines.
+func NewTickStreamWithContext(ctx context.Context, updateChan chan<- *Tick, errChan chan<- error,
+	options ...Option) *TickStream {
+	stream := NewTickStream(updateChan, errChan, options...)
+	stream.workers.Add(1)
+	go stream.disconnectOnDone(ctx)
+
+	return stream
+}
+
+// NewQuoteStreamWithContext works like NewQuoteStream, but the stream is disconnected as soon as the context is done.
+// This stops the reconnect watchdog and all other background goroutines.
+func NewQuoteStreamWithContext(ctx context.Context, updateChan chan<- *Quote, errChan chan<- error,
+	options ...Option) *QuoteStream {
+	stream := NewQuoteStream(updateChan, errChan, options...)
+	stream.workers.Add(1)
+	go stream.disconnectOnDone(ctx)
+
+	return stream
+}
+
+// disconnectOnDone disconnects the stream once the context is done. It returns early if the stream gets disconnected
+// otherwise.
+func (lms *stream) disconnectOnDone(ctx context.Context) {
+	stopped := lms.running("context watcher")
+
+	select {
+	case <-ctx.Done():
+		// Disconnect waits for all workers, this one included
+		stopped()
+		lms.workers.Done()
+		lms.Disconnect()
+
+	case <-lms.done:
+		stopped()
+		lms.workers.Done()
+	}
+}
@@ -0,0 +1,98 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// controlWriteTimeout is the deadline for answering pings and close frames
+const controlWriteTimeout = time.Second * 10
+
+// ControlFrame describes a WebSocket control frame received from lemon.markets
+type ControlFrame struct {
+	Type     int       // websocket.PingMessage, websocket.PongMessage or websocket.CloseMessage
+	Data     string    // Application data of pings and pongs
+	Code     int       // Close code. Only set for close frames
+	Reason   string    // Close reason. Only set for close frames
+	Received time.Time // Local time the frame was received
+}
+
+// ControlFrameObserver gets notified about every control frame. ObserveControlFrame is called from the reading
+// goroutine, so return quickly.
+type ControlFrameObserver interface {
+	ObserveControlFrame(frame *ControlFrame)
+}
+
+// SetControlFrameObserver sets an observer for pings, pongs and close frames. Pass nil to remove it.
+func (lms *stream) SetControlFrameObserver(observer ControlFrameObserver) {
+	lms.controlObserver = observer
+}
+
+func (lms *stream) observeControlFrame(frame *ControlFrame) {
+	if lms.controlObserver != nil {
+		lms.controlObserver.ObserveControlFrame(frame)
+	}
+}
+
+// installControlHandlers replaces the default control frame handlers with ones reporting to the observer. The
+// protocol behaviour of the defaults (answering pings and close frames) is kept.
+func (lms *stream) installControlHandlers(connection *websocket.Conn) {
+	connection.SetPingHandler(func(data string) error {
+		lms.observeControlFrame(&ControlFrame{Type: websocket.PingMessage, Data: data, Received: time.Now()})
+
+		err := connection.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(controlWriteTimeout))
+
+		if err == websocket.ErrCloseSent {
+			return nil
+		} else if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+			return nil
+		}
+
+		return err
+	})
+
+	connection.SetPongHandler(func(data string) error {
+		lms.observeControlFrame(&ControlFrame{Type: websocket.PongMessage, Data: data, Received: time.Now()})
+		lms.extendReadDeadline(connection)
+		return nil
+	})
+
+	connection.SetCloseHandler(func(code int, reason string) error {
+		lms.observeControlFrame(&ControlFrame{
+			Type:     websocket.CloseMessage,
+			Code:     code,
+			Reason:   reason,
+			Received: time.Now()})
+
+		message := websocket.FormatCloseMessage(code, "")
+		connection.WriteControl(websocket.CloseMessage, message, time.Now().Add(controlWriteTimeout))
+
+		return nil
+	})
+}
@@ -0,0 +1,107 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+var (
+	tickRequiredFields  = []string{"isin", "price"}
+	quoteRequiredFields = []string{"isin", "bid_price", "ask_price"}
+)
+
+// SetStrictDecoding switches between lenient decoding (the default), which ignores unknown fields and leaves missing
+// ones at their zero value, and strict decoding. In strict mode messages with unknown fields are rejected, as are
+// ticks without isin or price and quotes without isin, bid_price or ask_price. Rejected messages are reported in the
+// error channel and not delivered.
+func (lms *stream) SetStrictDecoding(strict bool) {
+	lms.strictDecoding = strict
+}
+
+// unmarshal decodes the message into the update following the configured strictness
+func (lms *stream) unmarshal(message []byte, update interface{}, requiredFields []string) error {
+	if lms.customUnmarshal != nil {
+		return lms.customUnmarshal(message, update)
+	}
+
+	if len(lms.fieldMapping) > 0 {
+		mapped, err := mapFields(message, lms.fieldMapping)
+
+		if err != nil {
+			return err
+		}
+
+		message = mapped
+	}
+
+	if !lms.strictDecoding {
+		return json.Unmarshal(message, update)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(update); err != nil {
+		return err
+	}
+
+	fields := make(map[string]json.RawMessage)
+
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return err
+	}
+
+	for _, field := range requiredFields {
+		if value, exists := fields[field]; !exists || string(value) == "null" {
+			return fmt.Errorf("%w: %s", ErrMissingField, field)
+		}
+	}
+
+	return nil
+}
+
+// mapFields renames the top level fields of a JSON object. Fields not in the mapping are kept.
+func mapFields(message []byte, mapping map[string]string) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return nil, err
+	}
+
+	mapped := make(map[string]json.RawMessage, len(fields))
+
+	for field, value := range fields {
+		if name, exists := mapping[field]; exists {
+			field = name
+		}
+
+		mapped[field] = value
+	}
+
+	return json.Marshal(mapped)
+}
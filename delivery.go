@@ -0,0 +1,273 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DropPolicy decides what happens to updates while the consumer of the update channel stalls
+type DropPolicy int
+
+const (
+	Drop_none   DropPolicy = iota // Block the stream until the consumer catches up or the buffer has room. Default
+	Drop_newest                   // Keep the buffered updates and drop the incoming one
+	Drop_oldest                   // Drop the oldest buffered update to make room for the incoming one
+)
+
+// defaultDeliveryBuffer is the number of updates buffered in front of the update channel with a drop policy
+const defaultDeliveryBuffer = 1024
+
+// WithDropPolicy enables the non-blocking delivery mode. Updates are queued in front of the update channel and a
+// stalling consumer never blocks reading from the WebSocket. Once the queue is full updates are dropped according to
+// the policy and counted, see DroppedUpdates. Routed updates are not affected.
+func WithDropPolicy(policy DropPolicy) Option {
+	return func(lms *stream) {
+		lms.dropPolicy = policy
+	}
+}
+
+// WithRingBuffer puts a ring buffer of the given size between reading from the WebSocket and the update channel, so
+// short consumer stalls don't block the read loop. overflow decides what happens once the buffer is full: Drop_none
+// waits for room, the other policies drop like WithDropPolicy. Routed updates are not affected.
+func WithRingBuffer(size int, overflow DropPolicy) Option {
+	return func(lms *stream) {
+		lms.deliverySize = size
+		lms.dropPolicy = overflow
+	}
+}
+
+// WithMaxDeliveryAge drops updates which waited longer than maxAge in the delivery queue instead of delivering them,
+// so a consumer never acts on a stale burst after a pause. Dropped updates are counted, see DroppedUpdates. Enables
+// the delivery queue with the default size if neither WithDropPolicy nor WithRingBuffer is used.
+func WithMaxDeliveryAge(maxAge time.Duration) Option {
+	return func(lms *stream) {
+		lms.maxDeliveryAge = maxAge
+	}
+}
+
+// DroppedUpdates returns the number of updates dropped by the drop policy or because they exceeded the maximum
+// delivery age
+func (lms *stream) DroppedUpdates() uint64 {
+	return atomic.LoadUint64(&lms.stats.policyDrops)
+}
+
+// queuedUpdate is an update waiting in the delivery queue
+type queuedUpdate struct {
+	update interface{}
+	queued time.Time
+}
+
+// deliveryQueue is a bounded ring buffer between the read loop and the update channel
+type deliveryQueue struct {
+	items    []queuedUpdate
+	head     int
+	count    int
+	inFlight bool          // An update was taken and is being delivered
+	notify   chan struct{} // Signals the delivery goroutine that updates are available
+	space    chan struct{} // Signals a waiting push that an update was taken
+	mutex    *sync.Mutex
+}
+
+func newDeliveryQueue(size int) *deliveryQueue {
+	if size < 1 {
+		size = 1
+	}
+
+	return &deliveryQueue{
+		items:  make([]queuedUpdate, size),
+		notify: make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+		mutex:  &sync.Mutex{}}
+}
+
+// push adds the update unless the queue is full. accepted is false if the update was not added, dropped is true if an
+// update was dropped because of the policy.
+func (queue *deliveryQueue) push(update interface{}, now time.Time, policy DropPolicy) (accepted bool, dropped bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.count == len(queue.items) {
+		switch policy {
+		case Drop_none:
+			return false, false
+
+		case Drop_newest:
+			return false, true
+		}
+
+		queue.items[queue.head] = queuedUpdate{}
+		queue.head = (queue.head + 1) % len(queue.items)
+		queue.count--
+		dropped = true
+	}
+
+	queue.items[(queue.head+queue.count)%len(queue.items)] = queuedUpdate{update: update, queued: now}
+	queue.count++
+
+	select {
+	case queue.notify <- struct{}{}:
+
+	default:
+	}
+
+	return true, dropped
+}
+
+// take removes the oldest update and marks it as in flight until the next take
+func (queue *deliveryQueue) take() (queuedUpdate, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.count == 0 {
+		queue.inFlight = false
+		return queuedUpdate{}, false
+	}
+
+	update := queue.items[queue.head]
+	queue.items[queue.head] = queuedUpdate{}
+	queue.head = (queue.head + 1) % len(queue.items)
+	queue.count--
+	queue.inFlight = true
+
+	select {
+	case queue.space <- struct{}{}:
+
+	default:
+	}
+
+	return update, true
+}
+
+// length returns the number of queued updates and the size of the queue
+func (queue *deliveryQueue) length() (int, int) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.count, len(queue.items)
+}
+
+// empty returns true once all updates were delivered
+func (queue *deliveryQueue) empty() bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.count == 0 && !queue.inFlight
+}
+
+// startDelivery puts the queue in front of sendUpdate if a drop policy or ring buffer is set
+func (lms *stream) startDelivery() {
+	if lms.dropPolicy == Drop_none && lms.deliverySize <= 0 && lms.maxDeliveryAge <= 0 {
+		return
+	}
+
+	size := lms.deliverySize
+
+	if size <= 0 {
+		size = defaultDeliveryBuffer
+	}
+
+	queue := newDeliveryQueue(size)
+	deliver := lms.sendUpdate
+	lms.delivery = queue
+
+	lms.sendUpdate = func(update interface{}) {
+		for {
+			accepted, dropped := queue.push(update, time.Now(), lms.dropPolicy)
+
+			if dropped {
+				lms.stats.count(&lms.stats.policyDrops)
+				lms.stats.count(&lms.stats.drops)
+			}
+
+			if accepted || dropped {
+				return
+			}
+
+			select {
+			case <-queue.space:
+
+			case <-lms.done:
+				return
+			}
+		}
+	}
+
+	lms.workers.Add(1)
+	go lms.deliveryWorker(queue, deliver)
+}
+
+// deliveryWorker hands queued updates to the update channel, blocking on the consumer instead of the read loop
+func (lms *stream) deliveryWorker(queue *deliveryQueue, deliver func(update interface{})) {
+	defer lms.workers.Done()
+	defer lms.running("delivery")()
+
+	for {
+		select {
+		case <-lms.done:
+			return
+
+		case <-queue.notify:
+		}
+
+		for queued, ok := queue.take(); ok; queued, ok = queue.take() {
+			if lms.maxDeliveryAge > 0 && time.Since(queued.queued) > lms.maxDeliveryAge {
+				lms.stats.count(&lms.stats.policyDrops)
+				lms.stats.count(&lms.stats.drops)
+				continue
+			}
+
+			deliver(queued.update)
+		}
+	}
+}
+
+// awaitDelivery waits until the delivery queue and the handler queues are empty or the deadline passed
+func (lms *stream) awaitDelivery(deadline time.Time) {
+	for !lms.delivered() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func (lms *stream) delivered() bool {
+	if lms.delivery != nil && !lms.delivery.empty() {
+		return false
+	}
+
+	lms.handlersMutex.Lock()
+	defer lms.handlersMutex.Unlock()
+
+	for _, pool := range lms.handlers {
+		for _, queue := range pool.queues {
+			if len(queue) > 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,124 @@
+package lemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBasket(t *testing.T) {
+	basket := NewBasket(map[string]float64{"A": 2, "B": 0.5}, time.Minute)
+	start := time.Date(2021, time.February, 19, 12, 0, 0, 0, time.UTC)
+
+	if _, ok := basket.update(&Tick{ISIN: "A", Price: 10}, start); ok {
+		t.Fatal("Basket emitted an index before all constituents had a price")
+	}
+
+	if _, ok := basket.update(&Tick{ISIN: "C", Price: 10}, start); ok {
+		t.Fatal("Basket accepted a tick for an unknown ISIN")
+	}
+
+	update, ok := basket.update(&Tick{ISIN: "B", Price: 4}, start.Add(2*time.Minute))
+
+	if !ok {
+		t.Fatal("Basket did not emit an index")
+	}
+
+	if update.Value != 22 {
+		t.Fatalf("Expected index value 22, got %f", update.Value)
+	}
+
+	if len(update.Stale) != 1 || update.Stale[0] != "A" {
+		t.Fatalf("Expected A to be stale, got %v", update.Stale)
+	}
+}
+
+func TestPair(t *testing.T) {
+	pair := NewPair("A", "B", 4)
+
+	if _, ok := pair.Update(&Tick{ISIN: "A", Price: 10}); ok {
+		t.Fatal("Pair emitted an update with only one leg priced")
+	}
+
+	update, _ := pair.Update(&Tick{ISIN: "B", Price: 5})
+
+	if update.Ratio != 2 || update.Spread != 5 || update.ZScore != 0 {
+		t.Fatalf("Unexpected first update: %+v", update)
+	}
+
+	pair.Update(&Tick{ISIN: "B", Price: 10})
+	update, _ = pair.Update(&Tick{ISIN: "A", Price: 30})
+
+	// Window: 2, 1, 3 -> mean 2, population deviation sqrt(2/3)
+	expected := 1 / 0.816496580927726
+
+	if diff := update.ZScore - expected; diff > 1e-9 || diff < -1e-9 {
+		t.Fatalf("Expected z-score %f, got %f", expected, update.ZScore)
+	}
+}
+
+func TestBreakoutDetector(t *testing.T) {
+	detector := NewBreakoutDetector()
+	detector.AddLevel("A", 12)
+	now := time.Date(2021, time.February, 19, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		price    float64
+		expected []BreakoutType
+	}{
+		{10, []BreakoutType{}},
+		{11, []BreakoutType{Breakout_session_high}},
+		{9, []BreakoutType{Breakout_session_low}},
+		{12.5, []BreakoutType{Breakout_session_high, Breakout_level_up}},
+		{11.5, []BreakoutType{Breakout_level_down}},
+	}
+
+	for counter, testCase := range testCases {
+		events := detector.update(&Tick{ISIN: "A", Price: testCase.price}, now)
+
+		if len(events) != len(testCase.expected) {
+			t.Fatalf("Test case #%d failed. Expected %d events, got %d", counter, len(testCase.expected), len(events))
+		}
+
+		for i, event := range events {
+			if event.Type != testCase.expected[i] {
+				t.Fatalf("Test case #%d failed. Expected %s, got %s", counter, testCase.expected[i], event.Type)
+			}
+		}
+	}
+
+	// A new day opens a new session, but a gap through a level is still reported
+	events := detector.update(&Tick{ISIN: "A", Price: 20}, now.Add(24*time.Hour))
+
+	if len(events) != 1 || events[0].Type != Breakout_level_up {
+		t.Fatalf("Expected only the level breakout on the first tick of a session, got %d events", len(events))
+	}
+
+	// Touching the level, reversing and breaking it again
+	detector.AddLevel("B", 100)
+	detector.update(&Tick{ISIN: "B", Price: 99}, now)
+
+	for counter, testCase := range []struct {
+		price    float64
+		expected BreakoutType
+	}{
+		{100, Breakout_level_up},
+		{99, Breakout_level_down},
+		{101, Breakout_level_up},
+	} {
+		found := false
+
+		for _, event := range detector.update(&Tick{ISIN: "B", Price: testCase.price}, now) {
+			if event.Type == Breakout_level_up || event.Type == Breakout_level_down {
+				if event.Type != testCase.expected || found {
+					t.Fatalf("Touch case #%d failed. Expected one %s, got %s", counter, testCase.expected, event.Type)
+				}
+
+				found = true
+			}
+		}
+
+		if !found {
+			t.Fatalf("Touch case #%d failed. Expected %s", counter, testCase.expected)
+		}
+	}
+}
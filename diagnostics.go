@@ -0,0 +1,141 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// stateHistorySize is the number of state transitions kept for Dump
+const stateHistorySize = 20
+
+type stateTransition struct {
+	state State
+	time  time.Time
+}
+
+// running counts a background goroutine of the given kind as running for Dump until the returned function is called
+func (lms *stream) running(kind string) func() {
+	lms.runningMutex.Lock()
+	lms.runningWorkers[kind]++
+	lms.runningMutex.Unlock()
+
+	return func() {
+		lms.runningMutex.Lock()
+		defer lms.runningMutex.Unlock()
+
+		if lms.runningWorkers[kind]--; lms.runningWorkers[kind] == 0 {
+			delete(lms.runningWorkers, kind)
+		}
+	}
+}
+
+// Dump writes a human readable report of the stream internals into w: state, background workers, channel and queue
+// occupancy, subscriptions and the most recent state transitions. It's meant for debugging stuck pipelines and the
+// format may change at any time.
+func (lms *stream) Dump(w io.Writer) error {
+	lms.stateMutex.Lock()
+	state := lms.state
+	history := append([]stateTransition{}, lms.stateHistory...)
+	lms.stateMutex.Unlock()
+
+	lms.subscriptionsMutex.Lock()
+	subscriptions := len(lms.subscriptions)
+	confirmed := len(lms.confirmed)
+	idle := len(lms.idleSince)
+	lms.subscriptionsMutex.Unlock()
+
+	updateLength, updateCapacity := lms.updateQueue()
+
+	lms.runningMutex.Lock()
+	kinds := make([]string, 0, len(lms.runningWorkers))
+	workers := make(map[string]int, len(lms.runningWorkers))
+
+	for kind, count := range lms.runningWorkers {
+		kinds = append(kinds, kind)
+		workers[kind] = count
+	}
+	lms.runningMutex.Unlock()
+
+	sort.Strings(kinds)
+
+	lines := []string{
+		fmt.Sprintf("State:              %s", state),
+		fmt.Sprintf("Endpoint:           %s", lms.currentEndpoint()),
+		fmt.Sprintf("Listener running:   %t", atomic.LoadInt32(&lms.listening) == 1),
+		fmt.Sprintf("Reconnect pending:  %t", len(lms.reconnectNotifier) > 0),
+		fmt.Sprintf("Failed reconnects:  %d", atomic.LoadInt32(&lms.failedReconnects)),
+		fmt.Sprintf("Goroutines (total): %d", runtime.NumGoroutine()),
+		fmt.Sprintf("Update channel:     %d/%d", updateLength, updateCapacity),
+		fmt.Sprintf("Error channel:      %d/%d", len(lms.errorChannel), cap(lms.errorChannel)),
+		fmt.Sprintf("Raw channel:        %d/%d", len(lms.rawMessages), cap(lms.rawMessages)),
+		fmt.Sprintf("Subscriptions:      %d (%d confirmed, %d idle)", subscriptions, confirmed, idle),
+	}
+
+	if lms.delivery != nil {
+		length, capacity := lms.delivery.length()
+		lines = append(lines, fmt.Sprintf("Delivery queue:     %d/%d", length, capacity))
+	}
+
+	lms.teesMutex.Lock()
+	for i, tee := range lms.tees {
+		lines = append(lines, fmt.Sprintf("Tee %d:              %d/%d (%d dropped)", i, len(tee.queue), cap(tee.queue),
+			tee.Dropped()))
+	}
+	lms.teesMutex.Unlock()
+
+	lms.handlersMutex.Lock()
+	for i, pool := range lms.handlers {
+		for j, queue := range pool.queues {
+			lines = append(lines, fmt.Sprintf("Handler %d/%d:        %d/%d", i, j, len(queue), cap(queue)))
+		}
+	}
+	lms.handlersMutex.Unlock()
+
+	lines = append(lines, "Workers:")
+
+	for _, kind := range kinds {
+		lines = append(lines, fmt.Sprintf("  %s: %d", kind, workers[kind]))
+	}
+
+	lines = append(lines, "State transitions:")
+
+	for _, transition := range history {
+		lines = append(lines, fmt.Sprintf("  %s %s", transition.time.Format(time.RFC3339Nano), transition.state))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
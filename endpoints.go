@@ -0,0 +1,93 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+// endpointFailoverThreshold is the number of failed connects after which the next endpoint is tried
+const endpointFailoverThreshold = 3
+
+// SetEndpoints configures an ordered list of WebSocket URLs. After repeated connect failures the stream rotates to the
+// next endpoint and wraps around at the end of the list. The list is used from the next (re)connect on.
+func (lms *stream) SetEndpoints(endpoints []string) {
+	lms.endpointMutex.Lock()
+	defer lms.endpointMutex.Unlock()
+
+	lms.endpoints = append([]string{}, endpoints...)
+	lms.endpointIndex = 0
+	lms.endpointFailures = 0
+}
+
+// SetEndpointChannel will take a channel where the URL of the active endpoint is sent into after every successful
+// connect. Keep in mind that you are the one in charge of maintaining and servicing the channel.
+func (lms *stream) SetEndpointChannel(channel chan<- string) {
+	lms.endpointMutex.Lock()
+	defer lms.endpointMutex.Unlock()
+
+	lms.endpointChannel = channel
+}
+
+// GetEndpoint returns the URL of the endpoint currently in use
+func (lms *stream) GetEndpoint() string {
+	return lms.currentEndpoint()
+}
+
+func (lms *stream) currentEndpoint() string {
+	lms.endpointMutex.Lock()
+	defer lms.endpointMutex.Unlock()
+
+	if len(lms.endpoints) == 0 {
+		return lms.getWebsocketUrl()
+	}
+
+	return lms.endpoints[lms.endpointIndex]
+}
+
+// endpointFailed counts a failed connect and rotates to the next endpoint once the threshold is reached
+func (lms *stream) endpointFailed() {
+	lms.endpointMutex.Lock()
+	defer lms.endpointMutex.Unlock()
+
+	lms.endpointFailures++
+
+	if lms.endpointFailures >= endpointFailoverThreshold && len(lms.endpoints) > 1 {
+		lms.endpointIndex = (lms.endpointIndex + 1) % len(lms.endpoints)
+		lms.endpointFailures = 0
+	}
+}
+
+// endpointConnected resets the failure counter and reports the active endpoint
+func (lms *stream) endpointConnected(endpoint string) {
+	lms.endpointMutex.Lock()
+	lms.endpointFailures = 0
+	channel := lms.endpointChannel
+	lms.endpointMutex.Unlock()
+
+	if channel != nil {
+		select {
+		case channel <- endpoint:
+
+		case <-lms.done:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+// Environment is a set of WebSocket URLs for both stream types
+type Environment struct {
+	Name     string // Human readable name
+	TickURL  string // URL of the tick stream
+	QuoteURL string // URL of the quote stream
+}
+
+// Production is the lemon.markets production environment. It's used by default.
+var Production = Environment{
+	Name:     "production",
+	TickURL:  "wss://api.lemon.markets/streams/v1/marketdata",
+	QuoteURL: "wss://api.lemon.markets/streams/v1/quotes"}
+
+// CustomEnvironment creates an environment for e.g. a staging gateway or a local replay server
+func CustomEnvironment(name, tickURL, quoteURL string) Environment {
+	return Environment{Name: name, TickURL: tickURL, QuoteURL: quoteURL}
+}
+
+// WithEnvironment connects to the URL of the environment matching the stream type. Use WithURL to set a single URL
+// directly.
+func WithEnvironment(environment Environment) Option {
+	return func(lms *stream) {
+		url := environment.TickURL
+
+		if _, isQuoteStream := lms.getUpdateType().(*Quote); isQuoteStream {
+			url = environment.QuoteURL
+		}
+
+		WithURL(url)(lms)
+	}
+}
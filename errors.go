@@ -0,0 +1,62 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "time"
+
+// recentErrorsSize is the number of errors kept for RecentErrors
+const recentErrorsSize = 100
+
+// reportError records the error and sends it into the error channel if there is one
+func (lms *stream) reportError(err error) {
+	lms.stats.count(&lms.stats.errors)
+
+	lms.errorsMutex.Lock()
+	lms.recentErrors = append(lms.recentErrors, err)
+
+	if len(lms.recentErrors) > recentErrorsSize {
+		lms.recentErrors = lms.recentErrors[1:]
+	}
+	lms.errorsMutex.Unlock()
+
+	lms.publish(&ErrorEvent{EventHeader: EventHeader{Time: time.Now()}, Err: err})
+
+	if lms.errorChannel != nil {
+		select {
+		case lms.errorChannel <- err:
+
+		case <-lms.done:
+		}
+	}
+}
+
+// RecentErrors returns the most recent errors, oldest first. Useful if the stream was created without an error
+// channel.
+func (lms *stream) RecentErrors() []error {
+	lms.errorsMutex.Lock()
+	defer lms.errorsMutex.Unlock()
+
+	return append([]error{}, lms.recentErrors...)
+}
@@ -0,0 +1,190 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Event is published on an EventBus. Use a type switch to tell the events apart. Own event types only have to
+// implement EventTime.
+type Event interface {
+	EventTime() time.Time
+}
+
+// EventHeader contains the fields shared by all events of this package
+type EventHeader struct {
+	Time time.Time // Time the event occurred
+}
+
+// EventTime returns the time the event occurred
+func (header EventHeader) EventTime() time.Time {
+	return header.Time
+}
+
+// UpdateEvent is published for every tick or quote delivered by a stream
+type UpdateEvent struct {
+	EventHeader
+	Update interface{} // *Tick or *Quote
+}
+
+// TickEvent carries a tick of a MarketDataStream
+type TickEvent struct {
+	EventHeader
+	Tick *Tick
+}
+
+// QuoteEvent carries a quote of a MarketDataStream
+type QuoteEvent struct {
+	EventHeader
+	Quote *Quote
+}
+
+// ConnectEvent is published after every successful connect
+type ConnectEvent struct {
+	EventHeader
+	Endpoint    string        // URL of the endpoint
+	Reconnected bool          // True if a lost connection was re-established
+	Attempts    int           // Number of connect attempts it took
+	Downtime    time.Duration // Time without connection. 0 on the first connect
+}
+
+// DisconnectEvent is published when the connection is lost unexpectedly
+type DisconnectEvent struct {
+	EventHeader
+	Err error // Reason the connection was lost
+}
+
+// StateEvent is published on every state change of a stream
+type StateEvent struct {
+	EventHeader
+	State State // The new state
+}
+
+// ErrorEvent is published for every error a stream reports
+type ErrorEvent struct {
+	EventHeader
+	Err error
+}
+
+// ConsistencyEvent is published for every tick outside of the prevailing quote, see TickStream.SetConsistencyChannel
+type ConsistencyEvent struct {
+	EventHeader
+	Violation *ConsistencyViolation
+}
+
+// SessionEvent is published by EventBus.RunCalendar when the exchange enters another session
+type SessionEvent struct {
+	EventHeader
+	Session  SessionType // The session just entered
+	Previous SessionType // The session before. Equals Session for the first event
+}
+
+// MarketOpenedEvent is published when a stream created with WithDeferredActivation activates, before it connects
+type MarketOpenedEvent struct {
+	EventHeader
+	Session SessionType // The session which just started
+}
+
+// EventHandler is called for every event published on an EventBus
+type EventHandler func(event Event)
+
+// EventBus hands published events to all registered handlers. One bus can be shared by several streams, see
+// WithEventBus. Handlers run on the goroutine publishing the event and block it, so return quickly.
+type EventBus struct {
+	handlers []EventHandler
+	mutex    *sync.Mutex
+}
+
+// NewEventBus creates a bus without handlers
+func NewEventBus() *EventBus {
+	return &EventBus{mutex: &sync.Mutex{}}
+}
+
+// Handle registers a handler for all events published from now on
+func (bus *EventBus) Handle(handler EventHandler) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.handlers = append(bus.handlers, handler)
+}
+
+// Publish hands the event to all handlers in the order they were registered
+func (bus *EventBus) Publish(event Event) {
+	bus.mutex.Lock()
+	handlers := bus.handlers
+	bus.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// defaultCalendarInterval is used by RunCalendar for intervals <= 0
+const defaultCalendarInterval = time.Minute
+
+// RunCalendar publishes a SessionEvent right away and whenever the exchange enters another session. The session is
+// checked every interval, or every minute if the interval is <= 0. Blocks until the context is done.
+func (bus *EventBus) RunCalendar(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultCalendarInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	now := time.Now()
+	previous := sessionAt(now)
+	bus.Publish(&SessionEvent{EventHeader: EventHeader{Time: now}, Session: previous, Previous: previous})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			if session := sessionAt(now); session != previous {
+				bus.Publish(&SessionEvent{EventHeader: EventHeader{Time: now}, Session: session, Previous: previous})
+				previous = session
+			}
+		}
+	}
+}
+
+// WithEventBus publishes the updates, connection events, state changes and errors of the stream on the bus
+func WithEventBus(bus *EventBus) Option {
+	return func(lms *stream) {
+		lms.events = bus
+	}
+}
+
+// publish hands the event to the bus of the stream if there is one
+func (lms *stream) publish(event Event) {
+	if lms.events != nil {
+		lms.events.Publish(event)
+	}
+}
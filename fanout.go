@@ -0,0 +1,103 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+// AddSubscriber registers another update channel. Every tick sent into the update channel is also sent into each
+// subscriber, in the order they were added. All consumers share the same tick, so don't modify it. A stalling
+// subscriber blocks the stream for everyone, use Tee if a consumer may fall behind. Keep in mind that you are the one
+// in charge of maintaining and servicing the channel.
+func (stream *TickStream) AddSubscriber(channel chan<- *Tick) {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	stream.subscribers = append(stream.subscribers, channel)
+}
+
+// RemoveSubscriber stops sending into the channel. A send which is already in progress still completes.
+func (stream *TickStream) RemoveSubscriber(channel chan<- *Tick) {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	for i, subscriber := range stream.subscribers {
+		if subscriber == channel {
+			stream.subscribers = append(stream.subscribers[:i:i], stream.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (stream *TickStream) fanOut(tick *Tick) {
+	stream.routesMutex.Lock()
+	subscribers := stream.subscribers
+	stream.routesMutex.Unlock()
+
+	for _, subscriber := range subscribers {
+		select {
+		case subscriber <- tick:
+
+		case <-stream.done:
+			return
+		}
+	}
+}
+
+// AddSubscriber registers another update channel. Every quote sent into the update channel is also sent into each
+// subscriber, in the order they were added. All consumers share the same quote, so don't modify it. A stalling
+// subscriber blocks the stream for everyone, use Tee if a consumer may fall behind. Keep in mind that you are the one
+// in charge of maintaining and servicing the channel.
+func (stream *QuoteStream) AddSubscriber(channel chan<- *Quote) {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	stream.subscribers = append(stream.subscribers, channel)
+}
+
+// RemoveSubscriber stops sending into the channel. A send which is already in progress still completes.
+func (stream *QuoteStream) RemoveSubscriber(channel chan<- *Quote) {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	for i, subscriber := range stream.subscribers {
+		if subscriber == channel {
+			stream.subscribers = append(stream.subscribers[:i:i], stream.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (stream *QuoteStream) fanOut(quote *Quote) {
+	stream.routesMutex.Lock()
+	subscribers := stream.subscribers
+	stream.routesMutex.Unlock()
+
+	for _, subscriber := range subscribers {
+		select {
+		case subscriber <- quote:
+
+		case <-stream.done:
+			return
+		}
+	}
+}
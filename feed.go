@@ -0,0 +1,244 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// feedClientBuffer is the number of updates buffered per feed client before updates for it are dropped
+const feedClientBuffer = 1000
+
+// maxFeedFrame is the largest frame ReadTickFeed and ReadQuoteFeed accept
+const maxFeedFrame = 1 << 20
+
+// feedWriteTimeout is the time a feed client may stall a single write before it gets disconnected
+const feedWriteTimeout = time.Second * 5
+
+// ErrFrameTooLarge is returned when a feed frame exceeds the maximum size. The feed is out of sync then
+var ErrFrameTooLarge error = errors.New("Feed frame too large")
+
+// ServeFeed shares the stream with other processes on the same host, e.g. through a listener created with
+// net.Listen("unix", path). Every accepted connection receives all ticks as frames of a 4 byte big endian length
+// followed by the JSON encoded tick. Read them with ReadTickFeed. Each client gets its own buffer, a slow client only
+// loses its own ticks. Clients which stall a write for 5 seconds are disconnected. Clients can't change the
+// subscriptions. Blocks until the listener fails or the stream is disconnected, which closes the listener.
+func (stream *TickStream) ServeFeed(listener net.Listener) error {
+	return stream.serveFeed(listener, func(connection net.Conn) {
+		updates := make(chan *Tick)
+		tee := stream.Tee(updates, feedClientBuffer)
+		defer stream.RemoveTee(tee)
+
+		stream.writeFeed(connection, func() (interface{}, bool) {
+			select {
+			case tick := <-updates:
+				return tick, true
+
+			case <-stream.done:
+				return nil, false
+			}
+		})
+	})
+}
+
+// ServeFeed shares the stream with other processes on the same host, e.g. through a listener created with
+// net.Listen("unix", path). Every accepted connection receives all quotes as frames of a 4 byte big endian length
+// followed by the JSON encoded quote. Read them with ReadQuoteFeed. Each client gets its own buffer, a slow client
+// only loses its own quotes. Clients which stall a write for 5 seconds are disconnected. Clients can't change the
+// subscriptions. Blocks until the listener fails or the stream is disconnected, which closes the listener.
+func (stream *QuoteStream) ServeFeed(listener net.Listener) error {
+	return stream.serveFeed(listener, func(connection net.Conn) {
+		updates := make(chan *Quote)
+		tee := stream.Tee(updates, feedClientBuffer)
+		defer stream.RemoveTee(tee)
+
+		stream.writeFeed(connection, func() (interface{}, bool) {
+			select {
+			case quote := <-updates:
+				return quote, true
+
+			case <-stream.done:
+				return nil, false
+			}
+		})
+	})
+}
+
+func (lms *stream) serveFeed(listener net.Listener, serve func(connection net.Conn)) error {
+	if lms.isDone() {
+		listener.Close()
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	// The worker lives until serveFeed returns, so Disconnect waits for the accept loop as well
+	lms.workers.Add(1)
+	go func() {
+		defer lms.workers.Done()
+		defer lms.running("feed listener")()
+
+		select {
+		case <-lms.done:
+			listener.Close()
+			<-stopped
+
+		case <-stopped:
+		}
+	}()
+
+	for {
+		connection, err := listener.Accept()
+
+		if err != nil {
+			if lms.isDone() {
+				return nil
+			}
+
+			return err
+		}
+
+		lms.workers.Add(1)
+		go lms.serveFeedClient(connection, serve)
+	}
+}
+
+// serveFeedClient serves one feed client. The connection is closed on Disconnect to unblock a pending write
+func (lms *stream) serveFeedClient(connection net.Conn, serve func(connection net.Conn)) {
+	defer lms.workers.Done()
+	defer lms.running("feed client")()
+
+	served := make(chan struct{})
+	defer close(served)
+
+	lms.workers.Add(1)
+	go func() {
+		defer lms.workers.Done()
+
+		select {
+		case <-lms.done:
+			connection.Close()
+
+		case <-served:
+		}
+	}()
+
+	serve(connection)
+}
+
+// writeFeed writes frames to the client until writing fails or there are no more updates
+func (lms *stream) writeFeed(connection net.Conn, next func() (interface{}, bool)) {
+	defer connection.Close()
+
+	header := make([]byte, 4)
+
+	for {
+		update, ok := next()
+
+		if !ok {
+			return
+		}
+
+		message, err := json.Marshal(update)
+
+		if err != nil {
+			lms.reportError(err)
+			continue
+		}
+
+		binary.BigEndian.PutUint32(header, uint32(len(message)))
+		connection.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+
+		if _, err := connection.Write(append(header, message...)); err != nil {
+			return
+		}
+	}
+}
+
+// readFeedFrame reads the next frame written by ServeFeed
+func readFeedFrame(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+
+	if length > maxFeedFrame {
+		return nil, ErrFrameTooLarge
+	}
+
+	message := make([]byte, length)
+	_, err := io.ReadFull(reader, message)
+
+	return message, err
+}
+
+// ReadTickFeed reads the ticks of a feed served by TickStream.ServeFeed into the channel. Returns the read error, which
+// is io.EOF once the serving process closed the connection. Keep in mind: You are responsible for the channel.
+func ReadTickFeed(connection io.Reader, updates chan<- *Tick) error {
+	for {
+		message, err := readFeedFrame(connection)
+
+		if err != nil {
+			return err
+		}
+
+		tick := &Tick{}
+
+		if err := json.Unmarshal(message, tick); err != nil {
+			return err
+		}
+
+		updates <- tick
+	}
+}
+
+// ReadQuoteFeed reads the quotes of a feed served by QuoteStream.ServeFeed into the channel. Returns the read error,
+// which is io.EOF once the serving process closed the connection. Keep in mind: You are responsible for the channel.
+func ReadQuoteFeed(connection io.Reader, updates chan<- *Quote) error {
+	for {
+		message, err := readFeedFrame(connection)
+
+		if err != nil {
+			return err
+		}
+
+		quote := &Quote{}
+
+		if err := json.Unmarshal(message, quote); err != nil {
+			return err
+		}
+
+		updates <- quote
+	}
+}
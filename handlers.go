@@ -0,0 +1,118 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "hash/fnv"
+
+// handlerQueueSize is the number of updates buffered per worker of a handler pool
+const handlerQueueSize = 100
+
+// handlerPool runs a callback on a fixed number of workers. Updates of one ISIN always go to the same worker, so they
+// are handled in order.
+type handlerPool struct {
+	queues []chan interface{}
+}
+
+// OnTick calls the handler for every tick on a pool of the given number of workers, as an alternative to consuming
+// the update channel. Handlers for the same ISIN never run concurrently and see the ticks in order. If all workers are
+// busy the stream waits, just like with a full update channel. Pass nil as update channel to NewTickStream if you
+// only want callbacks.
+func (stream *TickStream) OnTick(handler func(*Tick), workers int) {
+	stream.addHandler(workers, func(update interface{}) {
+		handler(update.(*Tick))
+	})
+}
+
+// OnQuote calls the handler for every quote on a pool of the given number of workers, as an alternative to consuming
+// the update channel. Handlers for the same ISIN never run concurrently and see the quotes in order. If all workers
+// are busy the stream waits, just like with a full update channel. Pass nil as update channel to NewQuoteStream if you
+// only want callbacks.
+func (stream *QuoteStream) OnQuote(handler func(*Quote), workers int) {
+	stream.addHandler(workers, func(update interface{}) {
+		handler(update.(*Quote))
+	})
+}
+
+func (lms *stream) addHandler(workers int, handler func(update interface{})) {
+	if lms.isDone() {
+		return
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	pool := &handlerPool{queues: make([]chan interface{}, workers)}
+
+	for i := range pool.queues {
+		pool.queues[i] = make(chan interface{}, handlerQueueSize)
+
+		lms.workers.Add(1)
+		go lms.runHandler(pool.queues[i], handler)
+	}
+
+	lms.handlersMutex.Lock()
+	lms.handlers = append(lms.handlers, pool)
+	lms.handlersMutex.Unlock()
+}
+
+func (lms *stream) runHandler(queue <-chan interface{}, handler func(update interface{})) {
+	defer lms.workers.Done()
+	defer lms.running("handler")()
+
+	for {
+		select {
+		case update := <-queue:
+			handler(update)
+
+		case <-lms.done:
+			return
+		}
+	}
+}
+
+// callHandlers hands the update to the worker responsible for its ISIN in every pool
+func (lms *stream) callHandlers(isin string, update interface{}) {
+	lms.handlersMutex.Lock()
+	pools := lms.handlers
+	lms.handlersMutex.Unlock()
+
+	if len(pools) == 0 {
+		return
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(isin))
+	sum := hash.Sum32()
+
+	for _, pool := range pools {
+		select {
+		case pool.queues[sum%uint32(len(pool.queues))] <- update:
+
+		case <-lms.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "time"
+
+// lifecycleHooks are user callbacks for connection events
+type lifecycleHooks struct {
+	onConnect    []func(endpoint string)
+	onDisconnect []func(err error)
+	onReconnect  []func(attempts int, downtime time.Duration)
+}
+
+// WithOnConnect registers a callback which is called after every successful connect with the URL of the endpoint.
+// Callbacks run on internal goroutines and block the stream, so return quickly.
+func WithOnConnect(callback func(endpoint string)) Option {
+	return func(lms *stream) {
+		lms.hooks.onConnect = append(lms.hooks.onConnect, callback)
+	}
+}
+
+// WithOnDisconnect registers a callback which is called when the connection is lost unexpectedly. It's not called on
+// Disconnect. Callbacks run on internal goroutines and block the stream, so return quickly.
+func WithOnDisconnect(callback func(err error)) Option {
+	return func(lms *stream) {
+		lms.hooks.onDisconnect = append(lms.hooks.onDisconnect, callback)
+	}
+}
+
+// WithOnReconnect registers a callback which is called when a lost connection is re-established. It gets the number
+// of connect attempts it took and the time without connection. It's called after the subscriptions were sent again
+// and after the OnConnect callbacks. Callbacks run on internal goroutines and block the stream, so return quickly.
+func WithOnReconnect(callback func(attempts int, downtime time.Duration)) Option {
+	return func(lms *stream) {
+		lms.hooks.onReconnect = append(lms.hooks.onReconnect, callback)
+	}
+}
+
+// connected runs the hooks of a successful connect
+func (lms *stream) connected(endpoint string, reconnected bool, attempts int) {
+	now := time.Now()
+	downtime := time.Duration(0)
+
+	if reconnected {
+		downtime = now.Sub(lms.lostAt)
+	}
+
+	for _, callback := range lms.hooks.onConnect {
+		callback(endpoint)
+	}
+
+	lms.publish(&ConnectEvent{EventHeader: EventHeader{Time: now}, Endpoint: endpoint, Reconnected: reconnected,
+		Attempts: attempts, Downtime: downtime})
+
+	if reconnected {
+		for _, callback := range lms.hooks.onReconnect {
+			callback(attempts, downtime)
+		}
+	}
+}
+
+// disconnected runs the hooks of a lost connection
+func (lms *stream) disconnected(err error) {
+	lms.lostAt = time.Now()
+
+	for _, callback := range lms.hooks.onDisconnect {
+		callback(err)
+	}
+
+	lms.publish(&DisconnectEvent{EventHeader: EventHeader{Time: lms.lostAt}, Err: err})
+}
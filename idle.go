@@ -0,0 +1,112 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"errors"
+	"time"
+)
+
+// MarkIdle marks a subscribed instrument as idle. If an idle timeout is set and the instrument is not marked active
+// again within that time it gets unsubscribed automatically. Marking an already idle instrument keeps the original
+// idle time.
+func (lms *stream) MarkIdle(isin string) {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	if _, subscribed := lms.subscriptions[isin]; !subscribed {
+		return
+	}
+
+	if _, idle := lms.idleSince[isin]; !idle {
+		lms.idleSince[isin] = time.Now()
+	}
+}
+
+// MarkActive removes the idle mark from an instrument
+func (lms *stream) MarkActive(isin string) {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	delete(lms.idleSince, isin)
+}
+
+// SetIdleTimeout enables the automatic unsubscribe of instruments which have been marked idle for at least the given
+// duration. Only the first call with a duration greater than 0 starts the background check.
+func (lms *stream) SetIdleTimeout(timeout time.Duration) {
+	lms.subscriptionsMutex.Lock()
+	start := lms.idleTimeout == 0 && timeout > 0
+	lms.idleTimeout = timeout
+	lms.subscriptionsMutex.Unlock()
+
+	if start && !lms.isDone() {
+		lms.workers.Add(1)
+		go lms.idleWatchdog()
+	}
+}
+
+// idleWatchdog periodically unsubscribes instruments which exceeded the idle timeout
+func (lms *stream) idleWatchdog() {
+	defer lms.workers.Done()
+	defer lms.running("idle watchdog")()
+
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lms.done:
+			return
+
+		case now := <-ticker.C:
+			for _, isin := range lms.expiredIdleISINs(now) {
+				if err := lms.Unsubscribe(isin); errors.Is(err, ErrQuotaExceeded) {
+					lms.reportError(err)
+				}
+			}
+		}
+	}
+}
+
+func (lms *stream) expiredIdleISINs(now time.Time) []string {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	expired := make([]string, 0)
+
+	if lms.idleTimeout == 0 {
+		return expired
+	}
+
+	timeout := time.Duration(lms.widen(sessionAt(now), float64(lms.idleTimeout)))
+
+	for isin, since := range lms.idleSince {
+		if now.Sub(since) >= timeout {
+			expired = append(expired, isin)
+		}
+	}
+
+	return expired
+}
@@ -0,0 +1,95 @@
+//go:build integration
+// +build integration
+
+package lemon
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// Run with: LEMON_API_KEY=... go test -tags integration -run Integration . during market hours. Set
+// LEMON_INTEGRATION_ISIN to use another instrument than the default.
+
+// integrationTimeout is how long an instrument may stay silent. Liquid instruments tick a lot faster
+const integrationTimeout = time.Minute
+
+func awaitIntegrationTick(t *testing.T, stream *ManagedTickStream, isin string) {
+	deadline := time.After(integrationTimeout)
+
+	for {
+		select {
+		case tick := <-stream.Updates():
+			if tick.ISIN == isin {
+				return
+			}
+
+		case err := <-stream.Errors():
+			t.Logf("Error: %s", err)
+
+		case <-deadline:
+			t.Fatalf("No tick for %s within %s", isin, integrationTimeout)
+		}
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	token := os.Getenv("LEMON_API_KEY")
+
+	if token == "" {
+		t.Skip("LEMON_API_KEY not set")
+	}
+
+	if !IsExchangeOpen() {
+		t.Skip("Exchange is closed")
+	}
+
+	isin := os.Getenv("LEMON_INTEGRATION_ISIN")
+
+	if isin == "" {
+		isin = "US88160R1014"
+	}
+
+	reconnected := make(chan struct{}, 1)
+
+	stream := NewManagedTickStream(100, WithAPIToken(token), WithBackoff(func(int) time.Duration { return 0 }),
+		WithOnReconnect(func(int, time.Duration) {
+			select {
+			case reconnected <- struct{}{}:
+
+			default:
+			}
+		}))
+	defer stream.Disconnect()
+
+	if state := stream.GetState(); state != State_connected {
+		t.Fatalf("Expected state %s, got %s", State_connected, state)
+	}
+
+	if err := stream.Subscribe(isin); err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+
+	awaitIntegrationTick(t, stream, isin)
+
+	// Kill the connection the hard way to force a reconnect with resubscription
+	stream.writeMutex.Lock()
+	stream.connection.Close()
+	stream.writeMutex.Unlock()
+
+	select {
+	case <-reconnected:
+
+	case <-time.After(integrationTimeout):
+		t.Fatal("No reconnect")
+	}
+
+	awaitIntegrationTick(t, stream, isin)
+
+	stream.Unsubscribe(isin)
+
+	if subscriptions := stream.GetSubscriptions(); len(subscriptions) != 0 {
+		t.Fatalf("Expected no subscriptions, got %v", subscriptions)
+	}
+}
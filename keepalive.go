@@ -0,0 +1,81 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WithKeepalive sends a ping every interval. If no pong arrives within interval plus pongTimeout the connection is
+// considered dead, ErrPongTimeout is sent into the error channel and a reconnect is triggered. Without keepalive a
+// silently dead connection is only noticed once a read fails, which can take minutes.
+func WithKeepalive(interval, pongTimeout time.Duration) Option {
+	return func(lms *stream) {
+		lms.pingInterval = interval
+		lms.pongTimeout = pongTimeout
+	}
+}
+
+// startKeepalive arms the read deadline and starts pinging the new connection
+func (lms *stream) startKeepalive(connection *websocket.Conn) {
+	if lms.pingInterval <= 0 {
+		return
+	}
+
+	lms.extendReadDeadline(connection)
+
+	lms.workers.Add(1)
+	go lms.keepalive(connection)
+}
+
+// extendReadDeadline gives the connection another ping interval plus pong timeout to prove it's alive
+func (lms *stream) extendReadDeadline(connection *websocket.Conn) {
+	if lms.pingInterval > 0 {
+		connection.SetReadDeadline(time.Now().Add(lms.pingInterval + lms.pongTimeout))
+	}
+}
+
+// keepalive pings the connection until writing fails, which happens once listen closed it
+func (lms *stream) keepalive(connection *websocket.Conn) {
+	defer lms.workers.Done()
+	defer lms.running("keepalive")()
+
+	ticker := time.NewTicker(lms.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lms.done:
+			return
+
+		case <-ticker.C:
+			if err := connection.WriteControl(websocket.PingMessage, nil, time.Now().Add(controlWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
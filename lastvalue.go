@@ -0,0 +1,111 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "time"
+
+// lastValue is the most recent update of an instrument
+type lastValue struct {
+	update   interface{}
+	received time.Time
+}
+
+// remember stores a copy of the update as the most recent one of the ISIN. The copy is taken before the update is
+// tagged and enriched, so it's never modified afterwards. Updates arriving after the ISIN was unsubscribed are ignored.
+func (lms *stream) remember(isin string, update interface{}, now time.Time) {
+	switch typed := update.(type) {
+	case *Tick:
+		tick := *typed
+		update = &tick
+
+	case *Quote:
+		quote := *typed
+		update = &quote
+	}
+
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	if _, subscribed := lms.subscriptions[isin]; subscribed {
+		lms.lastValues[isin] = lastValue{update: update, received: now}
+	}
+}
+
+// lastValueOf returns the most recent update of the ISIN
+func (lms *stream) lastValueOf(isin string) (lastValue, bool) {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	value, exists := lms.lastValues[isin]
+	return value, exists
+}
+
+// GetLastTick returns a copy of the most recent tick of a subscribed instrument and the time it was received. Returns
+// false if the instrument is not subscribed or did not produce a tick yet. Metadata, QuoteAge and Session are not set.
+func (stream *TickStream) GetLastTick(isin string) (*Tick, time.Time, bool) {
+	value, exists := stream.lastValueOf(isin)
+
+	if !exists {
+		return nil, time.Time{}, false
+	}
+
+	tick := *value.update.(*Tick)
+	return &tick, value.received, true
+}
+
+// GetLastQuote returns a copy of the most recent quote of a subscribed instrument and the time it was received.
+// Returns false if the instrument is not subscribed or did not produce a quote yet. Metadata and Session are not set.
+func (stream *QuoteStream) GetLastQuote(isin string) (*Quote, time.Time, bool) {
+	value, exists := stream.lastValueOf(isin)
+
+	if !exists {
+		return nil, time.Time{}, false
+	}
+
+	quote := *value.update.(*Quote)
+	return &quote, value.received, true
+}
+
+// enrich fills fields of the update which are derived from other updates
+func (lms *stream) enrich(update interface{}, now time.Time) {
+	lms.tagSession(update, now)
+
+	if tick, isTick := update.(*Tick); isTick {
+		tick.QuoteAge = -1
+
+		if lms.quoteSource != nil {
+			if quote, exists := lms.quoteSource.lastValueOf(tick.ISIN); exists {
+				tick.QuoteAge = now.Sub(quote.received)
+				lms.checkConsistency(tick, quote.update.(*Quote))
+			}
+		}
+	}
+}
+
+// SetQuoteSource links a quote stream to the tick stream. Every delivered tick then carries the age of the prevailing
+// quote of its instrument in QuoteAge. Pass nil to remove the link.
+func (stream *TickStream) SetQuoteSource(quotes *QuoteStream) {
+	stream.quoteSource = quotes
+}
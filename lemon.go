@@ -28,29 +28,47 @@ SOFTWARE.
 //
 // An instrument is for example a security or a commodity and is identified by an International Securities Identification Number or short: ISIN
 //
-// Lang und Schwarz, Market Maker, Xetra, opening hours
+// # Lang und Schwarz, Market Maker, Xetra, opening hours
 //
 // Lemon.markets is hooked up to Lang und Schwarz (L&S) Tradecenter, a market maker from germany. During the opening hours of Xetra, the digital exchange from the Frankfurt Stock Exchange, the spreads will not differ much. This is called "Referenzmarktprinzip".
 //
 // Current Xetra opening hours: https://www.xetra.com/xetra-en/trading/trading-calendar-and-trading-hours
 // Currennt Lang und Schwarz opening hours: https://www.ls-tc.de/de/handelszeiten
 //
-// Connecting to lemon.markets outside L&S' opening hours is pointess. See function IsExchangeOpen for more details.
+// Connecting to lemon.markets outside L&S' opening hours is pointess. See function IsExchangeOpen for more details and WithDeferredActivation to connect at the next opening.
 //
-// Use of channels
+// # Use of channels
 //
 // This library is using channels for the communication with your application. To be precise: It's using *your* channels. You are responsible for each channel! It's your decision if you use a buffered or unbuffered channel. It's your responsibility to open, close and empty them. Please make sure your receiver is fetching fast enough (< 10 seconds). Otherwise lemon.markets may close the stream.
 //
-// Disconnects
+// # Concurrency
+//
+// All methods of a stream may be called from multiple goroutines. Subscriptions are kept in a mutex protected registry
+// and every message to lemon.markets goes through a single serialized write path, so concurrent Subscribe and
+// Unsubscribe calls and the resubscription after a reconnect never interleave. Setters which only swap a
+// configuration value (e.g. SetRawMessageChannel or SetStrictDecoding) are meant to be called right after creating
+// the stream. Prefer the corresponding Option where there is one.
+//
+// # Ordering
+//
+// Every stream decodes and delivers its updates on a single goroutine, in the order they were received from
+// lemon.markets. Updates for the same ISIN are therefore never reordered. There is no ordering between a TickStream and
+// a QuoteStream or across reconnects.
+//
+// # Disconnects
 //
 // Connection state is interally monitored. If the connection drops a reconnect is automatically performed.
 package lemon
 
 import (
-	"encoding/json"
 	"errors"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -63,33 +81,114 @@ var (
 	// ErrConnectionClosed is returned when an active WebSocket connection closed. All further processing is stopped.
 	ErrConnectionClosed error = errors.New("Lemon markets connection closed")
 
-	// ErrUnknownISIN is returned when a subscription for an invalid or unknown ISIN occurred. This error does not stop message processing
+	// ErrUnknownISIN is returned wrapped in an UnknownISINError when a subscription for an invalid or unknown ISIN
+	// occurred. Use errors.Is to check for it. This error does not stop message processing
 	ErrUnknownISIN error = errors.New("Invalid ISIN")
 
 	// ErrInvalidRequest is returned when an invalud request was detected
 	ErrInvalidRequest error = errors.New("Invalid request detected")
 
+	// ErrNotConnected is returned when a message should be sent while there is no connection
+	ErrNotConnected error = errors.New("Not connected to lemon markets")
+
+	// ErrSubscriptionNotLive is returned wrapped in a SubscriptionError when a subscription did not produce any update
+	// after a reconnect although the exchange is open. This error does not stop message processing
+	ErrSubscriptionNotLive error = errors.New("Subscription did not produce any update after reconnect")
+
+	// ErrCircuitBreakerTripped is returned wrapped in a SubscriptionError when the circuit breaker paused the delivery
+	// of an instrument. This error does not stop message processing
+	ErrCircuitBreakerTripped error = errors.New("Circuit breaker tripped")
+
+	// ErrQuotaExceeded is returned wrapped in a SubscriptionError when a subscribe or unsubscribe was rejected because
+	// it would exceed the configured quota. This error does not stop message processing
+	ErrQuotaExceeded error = errors.New("Quota exceeded")
+
+	// ErrMissingField is returned in strict decoding mode when a message lacks a required field. This error does not
+	// stop message processing
+	ErrMissingField error = errors.New("Required field missing")
+
+	// ErrReconnectsExhausted is returned when the maximum number of reconnect attempts failed. All further processing is
+	// stopped.
+	ErrReconnectsExhausted error = errors.New("Reconnect attempts exhausted")
+
+	// ErrPongTimeout is returned when no pong arrived in time after a keepalive ping. A reconnect is triggered
+	ErrPongTimeout error = errors.New("Keepalive pong timed out")
+
+	// ErrStreamStale is returned when no message was received for the stale timeout during market hours. A reconnect is
+	// triggered
+	ErrStreamStale error = errors.New("No message received within stale timeout")
+
+	// ErrUnsubscribed is returned by SubscribeAndWait when the instrument gets unsubscribed before its first update
+	ErrUnsubscribed error = errors.New("Instrument unsubscribed while waiting for its first update")
+
 	// ErrNotImplemented is returned when the returned update did not match any type. This should never occure.
 	ErrNotImplemented error = errors.New("Update type not implemented. You should never see this")
 )
 
+// State is the connection state of a stream
+type State int
+
 const (
 	// Stream is initializing
-	State_init string = "initializing"
+	State_init State = iota
 
 	// Stream is connecting
-	State_connecting string = "connecting"
+	State_connecting
 
 	// Stream is connected
-	State_connected string = "connected"
+	State_connected
 
 	// Stream is disconnected. This is a final state. It's only reached after calling Disconnect()
-	State_disconnected string = "disconnected"
+	State_disconnected
 
 	// Stream is waiting to do a reconnect
-	State_waiting_to_reconnect string = "waiting to reconnect"
+	State_waiting_to_reconnect
+
+	// Stream gave up after the maximum number of reconnect attempts. This is a final state
+	State_reconnects_exhausted
+
+	// Stream is waiting for the exchange to open before connecting, see WithDeferredActivation
+	State_waiting_for_open
 )
 
+// String returns a human readable name of the state
+func (state State) String() string {
+	switch state {
+	case State_init:
+		return "initializing"
+
+	case State_connecting:
+		return "connecting"
+
+	case State_connected:
+		return "connected"
+
+	case State_disconnected:
+		return "disconnected"
+
+	case State_waiting_to_reconnect:
+		return "waiting to reconnect"
+
+	case State_reconnects_exhausted:
+		return "reconnects exhausted"
+
+	case State_waiting_for_open:
+		return "waiting for open"
+	}
+
+	return "unknown"
+}
+
+// IsConnected returns true if the stream is connected
+func (state State) IsConnected() bool {
+	return state == State_connected
+}
+
+// IsTerminal returns true if the stream reached a final state and won't connect again
+func (state State) IsTerminal() bool {
+	return state == State_disconnected || state == State_reconnects_exhausted
+}
+
 type lemonMarketSubscription struct {
 	Action    string `json:"action"`
 	Specifier string `json:"specifier"`
@@ -98,24 +197,54 @@ type lemonMarketSubscription struct {
 
 // Tick represents a price update.
 type Tick struct {
-	ISIN     string  `json:"isin"`     // ISIN of the instrument
-	Price    float64 `json:"price"`    // Current market price
-	Quantity uint    `json:"quantity"` // The quantity of the trade. If 0 then there was no actual trade but a simple price update
+	ISIN       string        `json:"isin"`     // ISIN of the instrument
+	Price      float64       `json:"price"`    // Current market price
+	Quantity   uint          `json:"quantity"` // The quantity of the trade. If 0 then there was no actual trade but a simple price update
+	Metadata   Metadata      `json:"-"`        // User metadata of the stream and subscription. Shared between updates, do not modify
+	QuoteAge   time.Duration `json:"-"`        // Age of the prevailing quote. Negative if unknown, see TickStream.SetQuoteSource
+	Session    SessionType   `json:"-"`        // Trading session the tick was received in
+	Indicative bool          `json:"-"`        // True if the price is indicative only, see WithWeekendMode
+	ReceivedAt time.Time     `json:"-"`        // Local time the tick was received
 }
 
 // Quote represents a quote update.
 type Quote struct {
-	ISIN    string  `json:"isin"`      // ISIN of the instrument
-	Bid     float64 `json:"bid_price"` // Current bid price
-	Ask     float64 `json:"ask_price"` // Current ask price
-	Bidsize uint64  `json:"bid_quan"`  // Current bid size
-	Asksize uint64  `json:"ask_quan"`  // Current ask size
+	ISIN       string      `json:"isin"`      // ISIN of the instrument
+	Bid        float64     `json:"bid_price"` // Current bid price
+	Ask        float64     `json:"ask_price"` // Current ask price
+	Bidsize    uint64      `json:"bid_quan"`  // Current bid size
+	Asksize    uint64      `json:"ask_quan"`  // Current ask size
+	Metadata   Metadata    `json:"-"`         // User metadata of the stream and subscription. Shared between updates, do not modify
+	Session    SessionType `json:"-"`         // Trading session the quote was received in
+	Indicative bool        `json:"-"`         // True if the prices are indicative only, see WithWeekendMode
+	ReceivedAt time.Time   `json:"-"`         // Local time the quote was received
+}
+
+// Spread returns the difference between ask and bid
+func (quote *Quote) Spread() float64 {
+	return quote.Ask - quote.Bid
+}
+
+// Mid returns the price halfway between bid and ask
+func (quote *Quote) Mid() float64 {
+	return (quote.Bid + quote.Ask) / 2
+}
+
+// SpreadPercent returns the spread in percent of the mid price. Returns 0 if the mid price is 0.
+func (quote *Quote) SpreadPercent() float64 {
+	mid := quote.Mid()
+
+	if mid == 0 {
+		return 0
+	}
+
+	return quote.Spread() / mid * 100
 }
 
 // stream contains values, functions and channels shared by TickStream and QuoteStream
 type stream struct {
 	connection         *websocket.Conn
-	processData        bool                                  // Read messages from the WebSocket
+	listening          int32                                 // 1 while a listen goroutine reads from the WebSocket. Accessed atomically
 	subscriptions      map[string]uint                       // All subscriptions the user did
 	subscriptionsMutex *sync.Mutex                           // Mutex for map access
 	getUpdateType      func() interface{}                    // Function returning the needed update type (tick or quote)
@@ -123,50 +252,199 @@ type stream struct {
 	getWebsocketUrl    func() string                         // Returns the websocket URL
 	getSubscription    func(string) *lemonMarketSubscription // Creates a subscription type with the needed values
 	reconnectNotifier  chan uint                             // Channel to notify reconnectWatchdog to do a reconnect. Channel is under our control!
-	failedReconnects   int
-	state              string        // Current state
-	errorChannel       chan<- error  // Channel where errors are sent into. Under user control!
-	rawMessages        chan<- []byte // Channel where raw messages from the WebSocket are sent into if not nil. Under user control!
+	workers            sync.WaitGroup                        // Background goroutines which may send into user channels
+	disconnectOnce     sync.Once                             // Disconnect may be called concurrently, e.g. by a context
+	failedReconnects   int32
+	failedAttempts     int                             // Failed connects in a row, not capped
+	maxReconnects      int                             // Give up after this many failed reconnects. 0 means never
+	lostAt             time.Time                       // Time the last connection was lost
+	hooks              lifecycleHooks                  // Callbacks for connection events
+	connects           int                             // Number of successful connects
+	verifyTimeout      time.Duration                   // Time subscriptions have to produce an update after a reconnect. 0 disables it
+	state              State                           // Current state. Guarded by stateMutex
+	stateHistory       []stateTransition               // Most recent state transitions. Guarded by stateMutex
+	stateMutex         *sync.Mutex                     // Mutex for the state fields
+	updateQueue        func() (int, int)               // Returns length and capacity of the update channel
+	errorChannel       chan<- error                    // Channel where errors are sent into. Under user control! May be nil
+	recentErrors       []error                         // Bounded buffer of the most recent errors. Guarded by errorsMutex
+	errorsMutex        *sync.Mutex                     // Mutex for recentErrors
+	rawMessages        chan<- []byte                   // Channel where raw messages from the WebSocket are sent into if not nil. Under user control!
+	done               chan struct{}                   // Closed on Disconnect to stop background goroutines
+	idleSince          map[string]time.Time            // ISINs explicitly marked idle and since when. Guarded by subscriptionsMutex
+	idleTimeout        time.Duration                   // Idle ISINs are unsubscribed after this duration. 0 disables the policy
+	confirmed          map[string]bool                 // ISINs which produced at least one update. Guarded by subscriptionsMutex
+	waiters            map[string][]chan struct{}      // Closed when the ISIN gets confirmed. Guarded by subscriptionsMutex
+	endpoints          []string                        // Ordered failover list of WebSocket URLs. Empty means getWebsocketUrl
+	endpointIndex      int                             // Index of the currently used endpoint
+	endpointFailures   int                             // Failed connects on the current endpoint
+	endpointChannel    chan<- string                   // Channel where the active endpoint is sent into after connecting. Under user control!
+	endpointMutex      *sync.Mutex                     // Mutex for the endpoint fields
+	writeMutex         *sync.Mutex                     // Serializes all writes to the connection
+	controlObserver    ControlFrameObserver            // Notified about pings, pongs and close frames if not nil
+	metadata           Metadata                        // Stream wide user metadata. Guarded by subscriptionsMutex
+	isinMetadata       map[string]Metadata             // Subscription metadata per ISIN. Guarded by subscriptionsMutex
+	mergedMetadata     map[string]Metadata             // Stream metadata overlaid with subscription metadata. Guarded by subscriptionsMutex
+	stats              counters                        // Internal counters, see GetStats
+	rate               *rateTracker                    // Observed update rates, see AdviseBufferSize
+	breaker            *circuitBreaker                 // Pauses delivery on implausible price moves if not nil
+	lastValues         map[string]lastValue            // Most recent update per ISIN. Guarded by subscriptionsMutex
+	quoteSource        *QuoteStream                    // Stream providing the prevailing quotes for ticks if not nil
+	consistency        chan<- *ConsistencyViolation    // Channel where tick/quote inconsistencies are sent into if not nil. Under user control!
+	consistencyPercent float64                         // Tolerance around bid/ask for the consistency check
+	quota              *quota                          // Limits outbound subscribe and unsubscribe messages
+	strictDecoding     bool                            // Reject unknown and missing fields
+	fieldMapping       map[string]string               // Renames fields of incoming messages before decoding
+	customUnmarshal    func([]byte, interface{}) error // Replaces the built-in decoding if not nil
+	dialer             *websocket.Dialer               // Dialer used to connect
+	dialerSettings     dialerSettings                  // Dialer settings of the options, see configureDialer
+	header             http.Header                     // Additional headers of the handshake request
+	backoff            func(int) time.Duration         // Returns the wait time before a reconnect for the number of failed reconnects
+	tracers            map[string]io.Writer            // Writers tracing single ISINs. Guarded by traceMutex
+	traceMutex         *sync.Mutex                     // Mutex for tracers
+	routeUpdate        func(interface{}) bool          // Sends the update into a matching route channel. Returns false if no route matched
+	routesMutex        *sync.Mutex                     // Mutex for the routes of TickStream and QuoteStream
+	batchThrottle      time.Duration                   // Pause between the messages of SubscribeAll and UnsubscribeAll
+	fastPath           func(interface{})               // Called with every decoded update instead of the regular delivery if not nil
+	authProvider       AuthProvider                    // Consulted for a token before every connect if not nil
+	tees               []*Tee                          // Additional delivery paths. Guarded by teesMutex
+	teesMutex          *sync.Mutex                     // Mutex for tees
+	weekendFactor      float64                         // Thresholds are multiplied with it during weekend sessions. 0 if weekend mode is off
+	events             *EventBus                       // Bus for all events of the stream if not nil
+	created            time.Time                       // Time the stream was created
+	statsBase          Stats                           // Counters persisted by earlier runs. Set before connecting
+	statsFile          string                          // File the counters are persisted to if not empty
+	statsInterval      time.Duration                   // Interval the counters are persisted in
+	pingInterval       time.Duration                   // Interval of keepalive pings. 0 if keepalive is off
+	pongTimeout        time.Duration                   // Time a pong may take after a keepalive ping
+	lastMessage        int64                           // Unix nanoseconds of the last message or connect. Accessed atomically
+	staleTimeout       time.Duration                   // Time without messages after which the connection is considered stale
+	stale              int32                           // 1 if the stale watchdog closed the connection. Accessed atomically
+	closing            int32                           // 1 once Disconnect started draining. Accessed atomically
+	listenDone         chan struct{}                   // Closed once the listen goroutine of the current connection returned. Guarded by writeMutex
+	drainTimeout       time.Duration                   // Maximum time Disconnect waits for outstanding messages
+	dropPolicy         DropPolicy                      // What to do with updates while the consumer stalls
+	delivery           *deliveryQueue                  // Queue in front of the update channel if a drop policy is set
+	deliverySize       int                             // Size of the delivery queue. 0 for the default size
+	handlers           []*handlerPool                  // Callbacks registered via OnTick or OnQuote. Guarded by handlersMutex
+	handlersMutex      *sync.Mutex                     // Mutex for handlers
+	closeChannels      func()                          // Closes the library owned channels after Disconnect if not nil
+	maxDeliveryAge     time.Duration                   // Queued updates older than this are dropped if greater than 0
+	deferActivation    bool                            // Don't connect before the exchange opens
+	runningWorkers     map[string]int                  // Number of running background goroutines by kind, see Dump. Guarded by runningMutex
+	runningMutex       *sync.Mutex                     // Mutex for runningWorkers
+	temporary          map[string]int                  // Holders of temporary subscriptions of one-shot queries. Guarded by temporaryMutex
+	temporaryMutex     *sync.Mutex                     // Serializes explicit subscribes with taking and releasing temporary subscriptions
 }
 
 // init initialized shared variables and channels and start the reconnect watchdog
 func (stream *stream) init() {
-	stream.state = State_init
+	stream.created = time.Now()
+	stream.stateMutex = &sync.Mutex{}
+	stream.errorsMutex = &sync.Mutex{}
+	stream.rate = &rateTracker{mutex: &sync.Mutex{}}
+	stream.setState(State_init)
 	stream.subscriptions = make(map[string]uint)
 	stream.subscriptionsMutex = &sync.Mutex{}
 	stream.reconnectNotifier = make(chan uint, 1)
-	stream.failedReconnects = 0
-
+	stream.done = make(chan struct{})
+	stream.idleSince = make(map[string]time.Time)
+	stream.confirmed = make(map[string]bool)
+	stream.waiters = make(map[string][]chan struct{})
+	stream.isinMetadata = make(map[string]Metadata)
+	stream.mergedMetadata = make(map[string]Metadata)
+	stream.lastValues = make(map[string]lastValue)
+	stream.quota = &quota{mutex: &sync.Mutex{}}
+	stream.dialer = websocket.DefaultDialer
+	stream.header = make(http.Header)
+	stream.backoff = defaultBackoff
+	stream.tracers = make(map[string]io.Writer)
+	stream.traceMutex = &sync.Mutex{}
+	stream.routesMutex = &sync.Mutex{}
+	stream.teesMutex = &sync.Mutex{}
+	stream.handlersMutex = &sync.Mutex{}
+	stream.endpointMutex = &sync.Mutex{}
+	stream.writeMutex = &sync.Mutex{}
+	stream.drainTimeout = defaultDrainTimeout
+	stream.runningWorkers = make(map[string]int)
+	stream.runningMutex = &sync.Mutex{}
+	stream.temporary = make(map[string]int)
+	stream.temporaryMutex = &sync.Mutex{}
+
+	stream.workers.Add(1)
 	go stream.reconnectWatchdog()
 }
 
 // reconnectWatchdog listens on the reconnectNotifier channel. Every time it pops something from it a reconnect to the
-// WebSocket is needed
+// WebSocket is needed. It stops once the stream is disconnected.
 func (stream *stream) reconnectWatchdog() {
-	for range stream.reconnectNotifier {
-		stream.state = State_waiting_to_reconnect
-		time.Sleep(time.Minute * time.Duration(stream.failedReconnects))
+	defer stream.workers.Done()
+	defer stream.running("reconnect watchdog")()
+
+	for {
+		select {
+		case <-stream.done:
+			return
+
+		case <-stream.reconnectNotifier:
+		}
+
+		stream.setState(State_waiting_to_reconnect)
 
-		stream.state = State_connecting
+		select {
+		case <-stream.done:
+			return
+
+		case <-time.After(stream.backoff(int(atomic.LoadInt32(&stream.failedReconnects)))):
+		}
+
+		stream.setState(State_connecting)
+		stream.stats.count(&stream.stats.reconnects)
 		stream.connect()
 	}
 }
 
+// requestReconnect notifies the reconnect watchdog unless the stream is disconnected
+func (lms *stream) requestReconnect() {
+	select {
+	case lms.reconnectNotifier <- 1:
+
+	case <-lms.done:
+	}
+}
+
+// isDone returns true once Disconnect was called
+func (lms *stream) isDone() bool {
+	select {
+	case <-lms.done:
+		return true
+
+	default:
+		return false
+	}
+}
+
 // TickStream streams ticks for the subscribed securities
 type TickStream struct {
 	stream
 	updateChannel chan<- *Tick
+	routes        []*tickRoute          // Guarded by routesMutex
+	isinChannels  map[string]chan *Tick // Per-ISIN channels. Guarded by routesMutex
+	subscribers   []chan<- *Tick        // Additional update channels. Guarded by routesMutex
 }
 
 // QuoteStream streams quotes for the subscribed securities
 type QuoteStream struct {
 	stream
 	updateChannel chan<- *Quote
+	routes        []*quoteRoute          // Guarded by routesMutex
+	isinChannels  map[string]chan *Quote // Per-ISIN channels. Guarded by routesMutex
+	subscribers   []chan<- *Quote        // Additional update channels. Guarded by routesMutex
 }
 
 // NewTickStream will initialize a new connection to stream ticks. Keep in mind: You are responsible for the passed
-// channels.
-func NewTickStream(updateChan chan<- *Tick, errChan chan<- error) *TickStream {
+// channels. The error channel may be nil, see RecentErrors. The update channel may be nil if ticks are only consumed
+// via OnTick, tees or routes. Options are applied before connecting.
+func NewTickStream(updateChan chan<- *Tick, errChan chan<- error, options ...Option) *TickStream {
 	stream := &TickStream{}
 	stream.init()
 	stream.errorChannel = errChan
@@ -177,11 +455,29 @@ func NewTickStream(updateChan chan<- *Tick, errChan chan<- error) *TickStream {
 	}
 
 	stream.sendUpdate = func(update interface{}) {
-		stream.updateChannel <- update.(*Tick)
+		if stream.updateChannel != nil {
+			select {
+			case stream.updateChannel <- update.(*Tick):
+
+			case <-stream.done:
+			}
+		}
+
+		stream.fanOut(update.(*Tick))
+	}
+
+	stream.updateQueue = func() (int, int) {
+		return len(stream.updateChannel), cap(stream.updateChannel)
+	}
+
+	stream.routeUpdate = func(update interface{}) bool {
+		return stream.route(update.(*Tick))
 	}
 
+	stream.closeChannels = stream.closeISINChannels
+
 	stream.getWebsocketUrl = func() string {
-		return "wss://api.lemon.markets/streams/v1/marketdata"
+		return Production.TickURL
 	}
 
 	stream.getSubscription = func(isin string) *lemonMarketSubscription {
@@ -191,16 +487,16 @@ func NewTickStream(updateChan chan<- *Tick, errChan chan<- error) *TickStream {
 			Specifier: "with-quantity-with-uncovered"}
 	}
 
-	stream.state = State_connecting
-
-	stream.connect()
+	stream.applyOptions(options)
+	stream.start()
 
 	return stream
 }
 
 // NewQuoteStream will initialize a new connection to stream quotes. Keep in mind: You are responsible for the passed
-// channels.
-func NewQuoteStream(updateChan chan<- *Quote, errChan chan<- error) *QuoteStream {
+// channels. The error channel may be nil, see RecentErrors. The update channel may be nil if quotes are only consumed
+// via OnQuote, tees or routes. Options are applied before connecting.
+func NewQuoteStream(updateChan chan<- *Quote, errChan chan<- error, options ...Option) *QuoteStream {
 	stream := &QuoteStream{}
 	stream.init()
 	stream.errorChannel = errChan
@@ -211,11 +507,29 @@ func NewQuoteStream(updateChan chan<- *Quote, errChan chan<- error) *QuoteStream
 	}
 
 	stream.sendUpdate = func(update interface{}) {
-		stream.updateChannel <- update.(*Quote)
+		if stream.updateChannel != nil {
+			select {
+			case stream.updateChannel <- update.(*Quote):
+
+			case <-stream.done:
+			}
+		}
+
+		stream.fanOut(update.(*Quote))
+	}
+
+	stream.updateQueue = func() (int, int) {
+		return len(stream.updateChannel), cap(stream.updateChannel)
+	}
+
+	stream.routeUpdate = func(update interface{}) bool {
+		return stream.route(update.(*Quote))
 	}
 
+	stream.closeChannels = stream.closeISINChannels
+
 	stream.getWebsocketUrl = func() string {
-		return "wss://api.lemon.markets/streams/v1/quotes"
+		return Production.QuoteURL
 	}
 
 	stream.getSubscription = func(isin string) *lemonMarketSubscription {
@@ -225,47 +539,132 @@ func NewQuoteStream(updateChan chan<- *Quote, errChan chan<- error) *QuoteStream
 			Specifier: "with-quantity-with-price"}
 	}
 
-	stream.state = State_connecting
-
-	stream.connect()
+	stream.applyOptions(options)
+	stream.start()
 
 	return stream
 }
 
-func (lms *stream) sendSubscription(subscription *lemonMarketSubscription) {
-	lms.connection.WriteJSON(subscription)
+func (lms *stream) sendSubscription(subscription *lemonMarketSubscription) error {
+	err := lms.writeJSON(subscription)
+	lms.trace(subscription.ISIN, "subscribe sent (error: %v)", err)
+
+	return err
+}
+
+// Subscribe to an instrument by supplying an ISIN. Double subscriptions are prevented silently. Returns
+// ErrNotConnected or the write error if the subscription could not be transmitted. The ISIN stays registered in that
+// case and is subscribed after the next reconnect. Returns a SubscriptionError wrapping ErrQuotaExceeded if the quota
+// rejected it; the ISIN is not registered then.
+func (lms *stream) Subscribe(isin string) error {
+	return lms.subscribe(isin)
+}
+
+// subscribe subscribes explicitly. An explicit subscription is kept when temporary holders release the ISIN.
+func (lms *stream) subscribe(isin string) error {
+	lms.temporaryMutex.Lock()
+	defer lms.temporaryMutex.Unlock()
+
+	delete(lms.temporary, isin)
+
+	return lms.addSubscription(isin)
 }
 
-// Subscribe to an instrument by supplying an ISIN. Double subscriptions are prevented silently.
-func (lms *stream) Subscribe(isin string) {
+// addSubscription registers the ISIN and sends the subscription. If sending fails the ISIN stays registered and is
+// subscribed again after the next reconnect.
+func (lms *stream) addSubscription(isin string) error {
+	if lms.isSubscribed(isin) {
+		return nil
+	}
+
+	if !lms.acquireQuota() {
+		lms.trace(isin, "subscribe rejected: quota exceeded")
+		return &SubscriptionError{ISIN: isin, Err: ErrQuotaExceeded}
+	}
+
 	lms.subscriptionsMutex.Lock()
 	defer lms.subscriptionsMutex.Unlock()
 
 	if _, exists := lms.subscriptions[isin]; !exists {
 		lms.subscriptions[isin] = 1
-		lms.sendSubscription(lms.getSubscription(isin))
+		return lms.sendSubscription(lms.getSubscription(isin))
 	}
+
+	return nil
 }
 
-// Unsubscribe to an instrument by supplying an ISIN. Double unsubscriptions are prevented silently.
-func (lms *stream) Unsubscribe(isin string) {
+// Unsubscribe to an instrument by supplying an ISIN. Double unsubscriptions are prevented silently. Returns a
+// SubscriptionError wrapping ErrQuotaExceeded if the quota rejected it; the ISIN stays subscribed then. Otherwise the
+// ISIN is removed and ErrNotConnected or the write error is returned if the message could not be transmitted.
+func (lms *stream) Unsubscribe(isin string) error {
+	return lms.unsubscribe(isin)
+}
+
+// unsubscribe removes the ISIN and sends the unsubscription
+func (lms *stream) unsubscribe(isin string) error {
+	if !lms.isSubscribed(isin) {
+		return nil
+	}
+
+	if !lms.acquireQuota() {
+		lms.trace(isin, "unsubscribe rejected: quota exceeded")
+		return &SubscriptionError{ISIN: isin, Err: ErrQuotaExceeded}
+	}
+
 	lms.subscriptionsMutex.Lock()
 	defer lms.subscriptionsMutex.Unlock()
 
 	if _, exists := lms.subscriptions[isin]; exists {
 		delete(lms.subscriptions, isin)
+		delete(lms.idleSince, isin)
+		delete(lms.confirmed, isin)
+		lms.releaseWaiters(isin)
+		delete(lms.lastValues, isin)
 
-		lms.connection.WriteJSON(&lemonMarketSubscription{
+		err := lms.writeJSON(&lemonMarketSubscription{
 			Action: "unsubscribe",
 			ISIN:   isin})
+
+		lms.trace(isin, "unsubscribe sent (error: %v)", err)
+
+		return err
 	}
+
+	return nil
 }
 
-// GetState returns a human readable connection state. See constants for possible values.
-func (lms *stream) GetState() string {
+func (lms *stream) isSubscribed(isin string) bool {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	_, exists := lms.subscriptions[isin]
+	return exists
+}
+
+// GetState returns the connection state. See constants for possible values.
+func (lms *stream) GetState() State {
+	lms.stateMutex.Lock()
+	defer lms.stateMutex.Unlock()
+
 	return lms.state
 }
 
+// setState changes the state and records the transition for Dump
+func (lms *stream) setState(state State) {
+	now := time.Now()
+
+	lms.stateMutex.Lock()
+	lms.state = state
+	lms.stateHistory = append(lms.stateHistory, stateTransition{state: state, time: now})
+
+	if len(lms.stateHistory) > stateHistorySize {
+		lms.stateHistory = lms.stateHistory[1:]
+	}
+	lms.stateMutex.Unlock()
+
+	lms.publish(&StateEvent{EventHeader: EventHeader{Time: now}, State: state})
+}
+
 // GetSubscriptions returns all stored subscriptions
 func (lms *stream) GetSubscriptions() []string {
 	lms.subscriptionsMutex.Lock()
@@ -280,64 +679,232 @@ func (lms *stream) GetSubscriptions() []string {
 	return subs
 }
 
-// Disconnect will disconnect from the WebSocket and clean up
+// SetSubscriptions subscribes and unsubscribes until the subscriptions match the desired ISINs. Only the necessary
+// messages are sent. Returns the ISINs which were actually added to and removed from the subscriptions and the errors
+// per ISIN. An ISIN rejected by the quota is neither added nor removed. ErrNotConnected is reported for ISINs which are
+// registered but only sent after the next reconnect, like with Subscribe.
+func (lms *stream) SetSubscriptions(desired []string) ([]string, []string, map[string]error) {
+	wanted := make(map[string]bool, len(desired))
+
+	for _, isin := range desired {
+		wanted[isin] = true
+	}
+
+	added := make([]string, 0)
+	removed := make([]string, 0)
+	errs := make(map[string]error)
+
+	for _, isin := range lms.GetSubscriptions() {
+		if !wanted[isin] {
+			if err := lms.unsubscribe(isin); err != nil {
+				errs[isin] = err
+			}
+
+			if !lms.isSubscribed(isin) {
+				removed = append(removed, isin)
+			}
+		}
+	}
+
+	for isin := range wanted {
+		if !lms.isSubscribed(isin) {
+			if err := lms.subscribe(isin); err != nil {
+				errs[isin] = err
+			}
+
+			if lms.isSubscribed(isin) {
+				added = append(added, isin)
+			}
+		}
+	}
+
+	return added, removed, errs
+}
+
+// Disconnect closes the WebSocket gracefully and cleans up. Messages lemon.markets sent before the close are still
+// delivered to the update channel, for at most the drain timeout (see WithDrainTimeout). Disconnect blocks until all
+// background goroutines have stopped, so don't call it from a callback or handler of the stream itself.
 func (lms *stream) Disconnect() {
-	lms.state = State_disconnected
-	lms.processData = false
-	lms.connection.Close()
-	close(lms.reconnectNotifier)
+	lms.drain()
+	lms.shutdown(State_disconnected)
+	lms.workers.Wait()
+
+	if lms.closeChannels != nil {
+		lms.closeChannels()
+	}
+}
+
+// drain sends a close frame and waits until listen delivered everything received before the close was answered
+func (lms *stream) drain() {
+	if !atomic.CompareAndSwapInt32(&lms.closing, 0, 1) || lms.isDone() {
+		return
+	}
+
+	lms.writeMutex.Lock()
+	connection := lms.connection
+	listenDone := lms.listenDone
+	lms.writeMutex.Unlock()
+
+	if connection == nil || atomic.LoadInt32(&lms.listening) == 0 {
+		return
+	}
+
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+
+	if err := connection.WriteControl(websocket.CloseMessage, message, time.Now().Add(controlWriteTimeout)); err != nil {
+		return
+	}
+
+	deadline := time.Now().Add(lms.drainTimeout)
+
+	select {
+	case <-listenDone:
+		lms.awaitDelivery(deadline)
+
+	case <-time.After(lms.drainTimeout):
+	}
+}
+
+// isClosing returns true once Disconnect was called, even while messages are still drained
+func (lms *stream) isClosing() bool {
+	return atomic.LoadInt32(&lms.closing) == 1 || lms.isDone()
+}
+
+// shutdown stops all processing and leaves the stream in the given final state
+func (lms *stream) shutdown(state State) {
+	lms.disconnectOnce.Do(func() {
+		lms.setState(state)
+		close(lms.done)
+
+		lms.writeMutex.Lock()
+		if lms.connection != nil {
+			lms.connection.Close()
+		}
+		lms.writeMutex.Unlock()
+	})
 }
 
 func (lms *stream) connect() {
-	connection, _, connectionError := websocket.DefaultDialer.Dial(lms.getWebsocketUrl(), nil)
+	if lms.isClosing() {
+		return
+	}
+
+	endpoint := lms.currentEndpoint()
+	connection, connectionError := lms.dial(endpoint)
 
 	if connectionError != nil {
-		lms.errorChannel <- ErrConnectFailed
+		lms.reportError(ErrConnectFailed)
 
-		if lms.failedReconnects <= 5 {
-			lms.failedReconnects++
+		if atomic.LoadInt32(&lms.failedReconnects) <= 5 {
+			atomic.AddInt32(&lms.failedReconnects, 1)
 		}
 
-		lms.reconnectNotifier <- 1
+		lms.endpointFailed()
+		lms.failedAttempts++
+
+		if lms.maxReconnects > 0 && lms.failedAttempts > lms.maxReconnects {
+			lms.reportError(ErrReconnectsExhausted)
+			lms.shutdown(State_reconnects_exhausted)
+			return
+		}
+
+		lms.requestReconnect()
 	} else {
-		lms.connection = connection
-		lms.processData = true
-		lms.failedReconnects = 0
-		lms.state = State_connected
+		attempts := lms.failedAttempts + 1
+		lms.failedAttempts = 0
+		lms.installControlHandlers(connection)
+		lms.startKeepalive(connection)
 
-		go lms.listen()
+		listenDone := make(chan struct{})
+
+		lms.writeMutex.Lock()
+		lms.connection = connection
+		lms.listenDone = listenDone
+		lms.writeMutex.Unlock()
+		atomic.StoreInt64(&lms.lastMessage, time.Now().UnixNano())
+		atomic.StoreInt32(&lms.failedReconnects, 0)
+		lms.setState(State_connected)
+		lms.endpointConnected(endpoint)
+		lms.connects++
+		reconnected := lms.connects > 1
+
+		lms.workers.Add(1)
+		go lms.listen(connection, listenDone)
 
 		lms.subscriptionsMutex.Lock()
+		if reconnected {
+			// Subscriptions have to prove again that they are live on the new connection
+			lms.confirmed = make(map[string]bool)
+		}
+
 		for isin := range lms.subscriptions {
+			lms.quota.record(time.Now())
 			lms.sendSubscription(lms.getSubscription(isin))
 		}
 		lms.subscriptionsMutex.Unlock()
+
+		if reconnected && lms.verifyTimeout > 0 {
+			lms.workers.Add(1)
+			go lms.verifySubscriptions(lms.verifyTimeout)
+		}
+
+		lms.connected(endpoint, reconnected, attempts)
 	}
 }
 
-func (lms *stream) listen() {
-	for lms.processData {
-		_, msg, err := lms.connection.ReadMessage()
+// listen reads and processes messages until the connection fails
+func (lms *stream) listen(connection *websocket.Conn, listenDone chan struct{}) {
+	defer lms.workers.Done()
+	defer lms.running("listener")()
+	defer close(listenDone)
+
+	atomic.StoreInt32(&lms.listening, 1)
+	defer atomic.StoreInt32(&lms.listening, 0)
+
+	for {
+		_, msg, err := connection.ReadMessage()
+
+		if err == nil {
+			lms.stats.count(&lms.stats.messages)
+			atomic.StoreInt64(&lms.lastMessage, time.Now().UnixNano())
+		}
 
 		if err != nil {
-			lms.processData = false
+			connection.Close()
+
+			if lms.isClosing() {
+				return
+			}
 
 			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
-				lms.errorChannel <- ErrConnectionClosed
-			} else {
-				lms.errorChannel <- err
+				err = ErrConnectionClosed
+			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() && lms.pingInterval > 0 {
+				err = ErrPongTimeout
 			}
 
-			if lms.state != State_disconnected {
-				lms.reconnectNotifier <- 1
+			if atomic.CompareAndSwapInt32(&lms.stale, 1, 0) {
+				err = ErrStreamStale
 			}
+
+			lms.reportError(err)
+
+			if !lms.isDone() {
+				lms.disconnected(err)
+				lms.requestReconnect()
+			}
+
+			return
 		} else if isUnknownISIN(msg) {
-			lms.errorChannel <- ErrUnknownISIN
+			lms.reportError(&UnknownISINError{ISIN: lms.unknownISIN(msg)})
 		} else if isInvalidRequest(msg) {
-			lms.errorChannel <- ErrInvalidRequest
+			lms.reportError(ErrInvalidRequest)
 		} else {
 			if lms.rawMessages != nil {
-				lms.rawMessages <- msg
+				select {
+				case lms.rawMessages <- msg:
+
+				case <-lms.done:
+				}
 			}
 
 			update := lms.getUpdateType()
@@ -345,30 +912,143 @@ func (lms *stream) listen() {
 
 			switch update.(type) {
 			case *Tick:
-				decodeError = json.Unmarshal(msg, update.(*Tick))
+				decodeError = lms.unmarshal(msg, update.(*Tick), tickRequiredFields)
 
 			case *Quote:
-				decodeError = json.Unmarshal(msg, update.(*Quote))
+				decodeError = lms.unmarshal(msg, update.(*Quote), quoteRequiredFields)
 
 			default:
 				decodeError = ErrNotImplemented
 			}
 
 			if decodeError != nil {
-				lms.errorChannel <- decodeError
+				lms.stats.count(&lms.stats.decodeErrors)
+				lms.reportError(decodeError)
 			} else {
-				lms.sendUpdate(update)
+				lms.dispatch(update)
 			}
 		}
 	}
 }
 
+// dispatch does the internal bookkeeping for a decoded update and hands it over to the user
+func (lms *stream) dispatch(update interface{}) {
+	isin := isinOf(update)
+	now := time.Now()
+
+	switch typed := update.(type) {
+	case *Tick:
+		typed.ReceivedAt = now
+
+	case *Quote:
+		typed.ReceivedAt = now
+	}
+
+	if lms.fastPath != nil {
+		lms.traceUpdate(isin, update, now)
+		lms.remember(isin, update, now)
+		lms.confirm(isin)
+		lms.fastPath(update)
+		lms.stats.count(&lms.stats.updates)
+		return
+	}
+
+	// A withheld update still proves the subscription is live, but must not become the last value. The last value is
+	// stored before confirming, it's what SubscribeAndWait based queries return
+	lms.traceUpdate(isin, update, now)
+	passes := lms.passesCircuitBreaker(isin, update)
+
+	if passes {
+		lms.remember(isin, update, now)
+	}
+
+	lms.confirm(isin)
+
+	if !passes {
+		lms.trace(isin, "update withheld by circuit breaker")
+		lms.stats.count(&lms.stats.drops)
+		return
+	}
+
+	lms.tag(isin, update)
+	lms.enrich(update, now)
+	lms.teeUpdate(update)
+	lms.callHandlers(isin, update)
+
+	if !lms.routeUpdate(update) {
+		lms.sendUpdate(update)
+	}
+
+	if lms.events != nil {
+		lms.events.Publish(&UpdateEvent{EventHeader: EventHeader{Time: now}, Update: update})
+	}
+
+	lms.stats.count(&lms.stats.updates)
+	lms.rate.observe(now)
+}
+
+// isinOf returns the ISIN of a tick or quote
+func isinOf(update interface{}) string {
+	switch typed := update.(type) {
+	case *Tick:
+		return typed.ISIN
+
+	case *Quote:
+		return typed.ISIN
+	}
+
+	return ""
+}
+
 // SetRawMessageChannel will take a channel where raw, untouched messages from the WebSocket will be sent into.
 // Keep in mind that you are the one in charge of maintaining and servicing the channel.
 func (lms *stream) SetRawMessageChannel(channel chan<- []byte) {
 	lms.rawMessages = channel
 }
 
+// UnknownISINError tells which subscription lemon.markets rejected as unknown. ISIN is empty if it could not be
+// determined from the server message.
+type UnknownISINError struct {
+	ISIN string
+}
+
+func (err *UnknownISINError) Error() string {
+	if err.ISIN == "" {
+		return ErrUnknownISIN.Error()
+	}
+
+	return ErrUnknownISIN.Error() + ": " + err.ISIN
+}
+
+// Is makes errors.Is(err, ErrUnknownISIN) work
+func (err *UnknownISINError) Is(target error) bool {
+	return target == ErrUnknownISIN
+}
+
+// isinPattern matches the format of an ISIN: Country code, nine alphanumerical characters and a check digit
+var isinPattern = regexp.MustCompile(`[A-Z]{2}[A-Z0-9]{9}[0-9]`)
+
+// unknownISIN extracts the rejected ISIN from the server message. Candidates are preferred if they are subscribed and
+// did not produce any update yet.
+func (lms *stream) unknownISIN(message []byte) string {
+	candidates := isinPattern.FindAllString(string(message), -1)
+
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	for _, candidate := range candidates {
+		if _, subscribed := lms.subscriptions[candidate]; subscribed && !lms.confirmed[candidate] {
+			return candidate
+		}
+	}
+
+	if len(candidates) > 0 {
+		return candidates[0]
+	}
+
+	return ""
+}
+
 func isUnknownISIN(message []byte) bool {
 	return strings.Contains(string(message), "This instrument does not exist")
 }
@@ -378,28 +1058,51 @@ func isInvalidRequest(message []byte) bool {
 }
 
 func isExchangeOpen(now time.Time) bool {
-	location, _ := time.LoadLocation("Europe/Berlin")
+	opening, closing := openingHours(now)
+	return now.After(opening) && now.Before(closing)
+}
+
+// openingHours returns the opening and closing time of the day of now
+func openingHours(now time.Time) (time.Time, time.Time) {
+	location := berlin()
 
-	openingHours := map[time.Weekday][4]int{
+	hoursPerWeekday := map[time.Weekday][4]int{
 		time.Saturday: {10, 0, 13, 0}, // 10:00 - 13:00
 		time.Sunday:   {17, 0, 19, 0}, // 17:00 - 19:00
 	}
 
-	var opening, closing time.Time
+	hours, exists := hoursPerWeekday[now.Weekday()]
 
-	if hours, exists := openingHours[now.Weekday()]; exists {
-		opening = time.Date(now.Year(), now.Month(), now.Day(), hours[0], hours[1], 0, 0, location)
-		closing = time.Date(now.Year(), now.Month(), now.Day(), hours[2], hours[3], 0, 0, location)
-	} else {
-		opening = time.Date(now.Year(), now.Month(), now.Day(), 7, 30, 0, 0, location)
-		closing = time.Date(now.Year(), now.Month(), now.Day(), 23, 0, 0, 0, location)
+	if !exists {
+		hours = [4]int{7, 30, 23, 0} // 07:30 - 23:00
 	}
 
-	return now.After(opening) && now.Before(closing)
+	opening := time.Date(now.Year(), now.Month(), now.Day(), hours[0], hours[1], 0, 0, location)
+	closing := time.Date(now.Year(), now.Month(), now.Day(), hours[2], hours[3], 0, 0, location)
+
+	return opening, closing
+}
+
+// nextOpening returns the next time the exchange opens after now. Returns now if the exchange is open.
+func nextOpening(now time.Time) time.Time {
+	local := now.In(berlin())
+
+	if isExchangeOpen(local) {
+		return now
+	}
+
+	for day := 0; day <= 7; day++ {
+		opening, _ := openingHours(local.AddDate(0, 0, day))
+
+		if !opening.Before(local) {
+			return opening
+		}
+	}
+
+	return now
 }
 
 // IsExchangeOpen returns true if Lang und Schwarz Tradecenter is currently operating.
 func IsExchangeOpen() bool {
-	location, _ := time.LoadLocation("Europe/Berlin")
-	return isExchangeOpen(time.Now().In(location))
+	return isExchangeOpen(time.Now().In(berlin()))
 }
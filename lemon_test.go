@@ -1,6 +1,8 @@
 package lemon
 
 import (
+	"errors"
+	"sync"
 	"testing"
 	"time"
 )
@@ -35,3 +37,324 @@ func TestIsExchangeOpen(t *testing.T) {
 		counter++
 	}
 }
+
+func TestSessionAt(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Berlin")
+
+	testCases := map[time.Time]SessionType{
+		time.Date(2021, time.February, 19, 12, 15, 0, 0, location): Session_regular,
+		time.Date(2021, time.February, 19, 23, 15, 0, 0, location): Session_closed,
+		time.Date(2021, time.February, 20, 10, 30, 0, 0, location): Session_weekend,
+		time.Date(2021, time.February, 21, 18, 0, 0, 0, time.UTC):  Session_closed,
+		time.Date(2021, time.February, 21, 17, 30, 0, 0, location): Session_weekend,
+	}
+
+	for thetime, expected := range testCases {
+		if result := sessionAt(thetime); result != expected {
+			t.Fatalf("Expected session %s at %s, got %s", expected, thetime, result)
+		}
+	}
+
+	lms := &stream{weekendFactor: 3}
+
+	if threshold := lms.widen(Session_weekend, 2); threshold != 6 {
+		t.Fatalf("Expected widened threshold 6, got %f", threshold)
+	}
+
+	if threshold := lms.widen(Session_regular, 2); threshold != 2 {
+		t.Fatalf("Expected unchanged threshold 2, got %f", threshold)
+	}
+}
+
+func TestQuoteDerivedFields(t *testing.T) {
+	quote := &Quote{Bid: 99, Ask: 101}
+
+	if quote.Spread() != 2 || quote.Mid() != 100 || quote.SpreadPercent() != 2 {
+		t.Fatalf("Unexpected derived fields: spread %f, mid %f, spread percent %f", quote.Spread(), quote.Mid(),
+			quote.SpreadPercent())
+	}
+
+	if empty := (&Quote{}); empty.SpreadPercent() != 0 {
+		t.Fatalf("Expected spread percent 0 without prices, got %f", empty.SpreadPercent())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := newCircuitBreaker(5, time.Minute, 2)
+	now := time.Date(2021, time.February, 19, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		price   float64
+		deliver bool
+		tripped bool
+	}{
+		{100, true, false},
+		{103, true, false},
+		{150, false, true}, // Bad tick
+		{102, true, false}, // Back at the old level
+		{150, false, true},
+		{151, false, false}, // First confirmation
+		{149, true, false},  // Second confirmation: New level accepted
+		{152, true, false},
+	}
+
+	for counter, testCase := range testCases {
+		deliver, tripped := breaker.check("A", testCase.price, now)
+
+		if deliver != testCase.deliver || tripped != testCase.tripped {
+			t.Fatalf("Test case #%d failed. Expected: %t/%t, Result: %t/%t", counter, testCase.deliver,
+				testCase.tripped, deliver, tripped)
+		}
+	}
+
+	// Moves outside of the window are not checked
+	if deliver, _ := breaker.check("A", 300, now.Add(time.Hour)); !deliver {
+		t.Fatal("Price move outside of the window was withheld")
+	}
+
+	// A zero mid is no reference for the next quote
+	breaker.check("B", 0, now)
+
+	if deliver, tripped := breaker.check("B", 100, now); !deliver || tripped {
+		t.Fatalf("Price after a zero reference withheld. Result: %t/%t", deliver, tripped)
+	}
+
+	if deliver, tripped := breaker.check("B", 150, now); deliver || !tripped {
+		t.Fatalf("Price move after a zero reference not checked. Result: %t/%t", deliver, tripped)
+	}
+}
+
+func TestIsInconsistent(t *testing.T) {
+	quote := &Quote{Bid: 99, Ask: 101}
+
+	testCases := map[float64]bool{
+		100:   false,
+		99:    false,
+		101.5: false, // Within 1% tolerance
+		98.5:  false,
+		97.9:  true,
+		102.1: true,
+	}
+
+	for price, expected := range testCases {
+		if result := isInconsistent(&Tick{Price: price}, quote, 1); result != expected {
+			t.Fatalf("Price %f failed. Expected: %t, Result: %t", price, expected, result)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	q := &quota{perMinute: 2, perHour: 3, mutex: &sync.Mutex{}}
+	now := time.Date(2021, time.February, 19, 12, 0, 0, 0, time.UTC)
+
+	if wait, _ := q.take(now); wait != 0 {
+		t.Fatal("Quota rejected messages within the limit")
+	}
+
+	if wait, _ := q.take(now); wait != 0 {
+		t.Fatal("Quota rejected messages within the limit")
+	}
+
+	if wait, _ := q.take(now.Add(time.Second * 30)); wait != time.Second*30 {
+		t.Fatalf("Expected to wait 30s for the minute quota, got %s", wait)
+	}
+
+	if wait, _ := q.take(now.Add(time.Minute)); wait != 0 {
+		t.Fatal("Quota did not free up after a minute")
+	}
+
+	if wait, _ := q.take(now.Add(time.Minute * 2)); wait != time.Minute*58 {
+		t.Fatalf("Expected to wait 58m for the hour quota, got %s", wait)
+	}
+}
+
+func TestStrictDecoding(t *testing.T) {
+	lms := &stream{strictDecoding: true}
+
+	testCases := map[string]bool{
+		`{"isin":"DE000TUAG000","price":1.5,"quantity":2}`:         true,
+		`{"isin":"DE000TUAG000","price":1.5}`:                      true,
+		`{"isin":"DE000TUAG000"}`:                                  false,
+		`{"isin":"DE000TUAG000","price":null}`:                     false,
+		`{"isin":"DE000TUAG000","price":1.5,"venue":"XMUN"}`:       false,
+		`{"isin":"DE000TUAG000","price":1.5,"quantity":"invalid"}`: false,
+	}
+
+	for message, expected := range testCases {
+		err := lms.unmarshal([]byte(message), &Tick{}, tickRequiredFields)
+
+		if (err == nil) != expected {
+			t.Fatalf("Message %s failed. Expected success: %t, Error: %v", message, expected, err)
+		}
+	}
+
+	lms.strictDecoding = false
+
+	if err := lms.unmarshal([]byte(`{"isin":"DE000TUAG000","venue":"XMUN"}`), &Tick{}, tickRequiredFields); err != nil {
+		t.Fatalf("Lenient decoding failed: %s", err)
+	}
+}
+
+func TestFieldMapping(t *testing.T) {
+	lms := &stream{strictDecoding: true}
+	WithFieldMapping(map[string]string{"b": "bid_price", "a": "ask_price"})(lms)
+
+	quote := &Quote{}
+
+	if err := lms.unmarshal([]byte(`{"isin":"DE000TUAG000","b":1.5,"a":1.6}`), quote, quoteRequiredFields); err != nil {
+		t.Fatalf("Decoding failed: %s", err)
+	}
+
+	if quote.Bid != 1.5 || quote.Ask != 1.6 {
+		t.Fatalf("Fields were not mapped: %+v", quote)
+	}
+}
+
+func TestState(t *testing.T) {
+	if State_waiting_to_reconnect.String() != "waiting to reconnect" {
+		t.Fatalf("Unexpected name: %s", State_waiting_to_reconnect)
+	}
+
+	if !State_connected.IsConnected() || State_connecting.IsConnected() {
+		t.Fatal("IsConnected is wrong")
+	}
+
+	terminal := map[State]bool{
+		State_init:                 false,
+		State_connecting:           false,
+		State_connected:            false,
+		State_disconnected:         true,
+		State_waiting_to_reconnect: false,
+		State_reconnects_exhausted: true,
+	}
+
+	for state, expected := range terminal {
+		if state.IsTerminal() != expected {
+			t.Fatalf("IsTerminal of %s failed. Expected: %t", state, expected)
+		}
+	}
+}
+
+func TestUnknownISINError(t *testing.T) {
+	lms := &stream{
+		subscriptions:      map[string]uint{"DE000TUAG000": 1, "US00165C1045": 1},
+		confirmed:          map[string]bool{"DE000TUAG000": true},
+		subscriptionsMutex: &sync.Mutex{}}
+
+	testCases := map[string]string{
+		`{"error":"This instrument does not exist: US00165C1045"}`:                "US00165C1045",
+		`{"error":"This instrument does not exist","value":"XX0000000000"}`:       "XX0000000000",
+		`{"error":"This instrument does not exist"}`:                              "",
+		`{"error":"This instrument does not exist DE000TUAG000 vs US00165C1045"}`: "US00165C1045",
+	}
+
+	for message, expected := range testCases {
+		err := &UnknownISINError{ISIN: lms.unknownISIN([]byte(message))}
+
+		if err.ISIN != expected {
+			t.Fatalf("Message %s failed. Expected: %s, Result: %s", message, expected, err.ISIN)
+		}
+
+		if !errors.Is(err, ErrUnknownISIN) {
+			t.Fatal("UnknownISINError does not match ErrUnknownISIN")
+		}
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Berlin")
+	lastMessage := time.Date(2021, time.February, 19, 12, 0, 0, 0, location)
+
+	lms := &stream{}
+	lms.init()
+	defer lms.shutdown(State_disconnected)
+
+	lms.staleTimeout = time.Minute
+	lms.lastMessage = lastMessage.UnixNano()
+	lms.setState(State_connected)
+
+	if lms.isStale(lastMessage.Add(time.Minute * 2)) {
+		t.Fatal("Stale without subscriptions")
+	}
+
+	lms.subscriptions["DE000TUAG000"] = 1
+
+	testCases := map[time.Time]bool{
+		lastMessage.Add(time.Second * 30): false,
+		lastMessage.Add(time.Minute * 2):  true,
+		// Friday 23:15, exchange closed
+		lastMessage.Add(time.Hour*11 + time.Minute*15): false,
+	}
+
+	for now, expected := range testCases {
+		if result := lms.isStale(now); result != expected {
+			t.Fatalf("Expected stale %t at %s, got %t", expected, now, result)
+		}
+	}
+}
+
+func TestDeliveryQueue(t *testing.T) {
+	testCases := map[DropPolicy][]int{
+		Drop_none:   {1, 2, 3},
+		Drop_newest: {1, 2, 3},
+		Drop_oldest: {3, 4, 5},
+	}
+
+	for policy, expected := range testCases {
+		queue := newDeliveryQueue(3)
+		rejected, dropped := 0, 0
+
+		for i := 1; i <= 5; i++ {
+			accepted, drop := queue.push(i, time.Now(), policy)
+
+			if !accepted {
+				rejected++
+			}
+
+			if drop {
+				dropped++
+			}
+		}
+
+		if policy == Drop_none && (rejected != 2 || dropped != 0) {
+			t.Fatalf("Policy %d: expected 2 rejected updates without drops, got %d and %d", policy, rejected, dropped)
+		} else if policy != Drop_none && dropped != 2 {
+			t.Fatalf("Policy %d: expected 2 drops, got %d", policy, dropped)
+		}
+
+		for _, value := range expected {
+			if queued, ok := queue.take(); !ok || queued.update.(int) != value {
+				t.Fatalf("Policy %d: expected %d, got %v", policy, value, queued.update)
+			}
+		}
+
+		if queue.empty() {
+			t.Fatalf("Policy %d: empty while the last update is in flight", policy)
+		}
+
+		if _, ok := queue.take(); ok || !queue.empty() {
+			t.Fatalf("Policy %d: expected an empty queue", policy)
+		}
+	}
+}
+
+func TestNextOpening(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Berlin")
+
+	testCases := map[time.Time]time.Time{
+		// Friday after closing -> Saturday session
+		time.Date(2021, time.February, 19, 23, 15, 0, 0, location): time.Date(2021, time.February, 20, 10, 0, 0, 0, location),
+		// Open -> now
+		time.Date(2021, time.February, 20, 12, 0, 0, 0, location): time.Date(2021, time.February, 20, 12, 0, 0, 0, location),
+		// Sunday after closing -> Monday
+		time.Date(2021, time.February, 21, 20, 0, 0, 0, location): time.Date(2021, time.February, 22, 7, 30, 0, 0, location),
+		// Monday morning
+		time.Date(2021, time.February, 22, 5, 0, 0, 0, location): time.Date(2021, time.February, 22, 7, 30, 0, 0, location),
+	}
+
+	for now, expected := range testCases {
+		if result := nextOpening(now); !result.Equal(expected) {
+			t.Fatalf("Expected next opening %s at %s, got %s", expected, now, result)
+		}
+	}
+}
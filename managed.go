@@ -0,0 +1,125 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "sync"
+
+// ManagedTickStream is a TickStream whose channels are created and owned by the library. They are closed after
+// Disconnect once all background goroutines have stopped, so ranging over them terminates cleanly.
+type ManagedTickStream struct {
+	*TickStream
+	updates   chan *Tick
+	errors    chan error
+	closeOnce *sync.Once
+}
+
+// ManagedQuoteStream is a QuoteStream whose channels are created and owned by the library. They are closed after
+// Disconnect once all background goroutines have stopped, so ranging over them terminates cleanly.
+type ManagedQuoteStream struct {
+	*QuoteStream
+	updates   chan *Quote
+	errors    chan error
+	closeOnce *sync.Once
+}
+
+// NewManagedTickStream creates a tick stream with library owned channels of the given capacity. The error channel has
+// room for at least one error, so a failed initial connect does not block the constructor. Make sure you read from
+// both channels.
+func NewManagedTickStream(bufferSize int, options ...Option) *ManagedTickStream {
+	updates := make(chan *Tick, bufferSize)
+	errors := make(chan error, errorBufferSize(bufferSize))
+
+	return &ManagedTickStream{
+		TickStream: NewTickStream(updates, errors, options...),
+		updates:    updates,
+		errors:     errors,
+		closeOnce:  &sync.Once{}}
+}
+
+// NewManagedQuoteStream creates a quote stream with library owned channels of the given capacity. The error channel
+// has room for at least one error, so a failed initial connect does not block the constructor. Make sure you read
+// from both channels.
+func NewManagedQuoteStream(bufferSize int, options ...Option) *ManagedQuoteStream {
+	updates := make(chan *Quote, bufferSize)
+	errors := make(chan error, errorBufferSize(bufferSize))
+
+	return &ManagedQuoteStream{
+		QuoteStream: NewQuoteStream(updates, errors, options...),
+		updates:     updates,
+		errors:      errors,
+		closeOnce:   &sync.Once{}}
+}
+
+// Updates returns the channel ticks are delivered into
+func (managed *ManagedTickStream) Updates() <-chan *Tick {
+	return managed.updates
+}
+
+// Errors returns the channel errors are delivered into
+func (managed *ManagedTickStream) Errors() <-chan error {
+	return managed.errors
+}
+
+// Disconnect disconnects from the WebSocket, waits for all background goroutines to stop and closes both channels.
+// Updates still buffered in the channels can be read afterwards.
+func (managed *ManagedTickStream) Disconnect() {
+	managed.TickStream.Disconnect()
+
+	managed.closeOnce.Do(func() {
+		managed.workers.Wait()
+		close(managed.updates)
+		close(managed.errors)
+	})
+}
+
+// Updates returns the channel quotes are delivered into
+func (managed *ManagedQuoteStream) Updates() <-chan *Quote {
+	return managed.updates
+}
+
+// Errors returns the channel errors are delivered into
+func (managed *ManagedQuoteStream) Errors() <-chan error {
+	return managed.errors
+}
+
+// Disconnect disconnects from the WebSocket, waits for all background goroutines to stop and closes both channels.
+// Updates still buffered in the channels can be read afterwards.
+func (managed *ManagedQuoteStream) Disconnect() {
+	managed.QuoteStream.Disconnect()
+
+	managed.closeOnce.Do(func() {
+		managed.workers.Wait()
+		close(managed.updates)
+		close(managed.errors)
+	})
+}
+
+func errorBufferSize(bufferSize int) int {
+	if bufferSize < 1 {
+		return 1
+	}
+
+	return bufferSize
+}
@@ -0,0 +1,142 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "time"
+
+// MarketDataStream manages a TickStream and a QuoteStream with one shared subscription list. Ticks and quotes are
+// delivered as TickEvent and QuoteEvent into a single event channel. Use a type switch to tell them apart.
+type MarketDataStream struct {
+	ticks  *TickStream
+	quotes *QuoteStream
+	events chan<- Event
+}
+
+// NewMarketDataStream connects to both streams. Keep in mind: You are responsible for the passed channels. Both streams
+// report into the same error channel, which may be nil. Each stream gets its own options, so stream specific options
+// like WithURL or WithStatsFile can't end up on the wrong stream. Pass the same slice twice for shared settings.
+func NewMarketDataStream(events chan<- Event, errChan chan<- error, tickOptions, quoteOptions []Option) *MarketDataStream {
+	market := &MarketDataStream{
+		ticks:  NewTickStream(nil, errChan, tickOptions...),
+		quotes: NewQuoteStream(nil, errChan, quoteOptions...),
+		events: events}
+
+	// One worker per stream keeps the order of updates
+	market.ticks.OnTick(func(tick *Tick) {
+		market.send(&TickEvent{EventHeader: EventHeader{Time: time.Now()}, Tick: tick}, market.ticks.done)
+	}, 1)
+
+	market.quotes.OnQuote(func(quote *Quote) {
+		market.send(&QuoteEvent{EventHeader: EventHeader{Time: time.Now()}, Quote: quote}, market.quotes.done)
+	}, 1)
+
+	return market
+}
+
+func (market *MarketDataStream) send(event Event, done <-chan struct{}) {
+	select {
+	case market.events <- event:
+
+	case <-done:
+	}
+}
+
+// Ticks returns the underlying tick stream, e.g. for tick specific settings
+func (market *MarketDataStream) Ticks() *TickStream {
+	return market.ticks
+}
+
+// Quotes returns the underlying quote stream, e.g. for quote specific settings
+func (market *MarketDataStream) Quotes() *QuoteStream {
+	return market.quotes
+}
+
+// Subscribe subscribes the instrument on both streams. Returns the first error, see TickStream.Subscribe for the
+// details. The other stream is subscribed nonetheless.
+func (market *MarketDataStream) Subscribe(isin string) error {
+	tickErr := market.ticks.Subscribe(isin)
+	quoteErr := market.quotes.Subscribe(isin)
+
+	if tickErr != nil {
+		return tickErr
+	}
+
+	return quoteErr
+}
+
+// Unsubscribe unsubscribes the instrument on both streams. Returns the first error, see TickStream.Unsubscribe for the
+// details. The other stream is unsubscribed nonetheless.
+func (market *MarketDataStream) Unsubscribe(isin string) error {
+	tickErr := market.ticks.Unsubscribe(isin)
+	quoteErr := market.quotes.Unsubscribe(isin)
+
+	if tickErr != nil {
+		return tickErr
+	}
+
+	return quoteErr
+}
+
+// SetSubscriptions subscribes and unsubscribes on both streams until the subscriptions match the desired ISINs.
+// Returns the ISINs added and removed on at least one of the streams and the errors of both, see
+// TickStream.SetSubscriptions. If an ISIN failed on both streams the error of the tick stream is returned.
+func (market *MarketDataStream) SetSubscriptions(desired []string) ([]string, []string, map[string]error) {
+	tickAdded, tickRemoved, errs := market.ticks.SetSubscriptions(desired)
+	quoteAdded, quoteRemoved, quoteErrs := market.quotes.SetSubscriptions(desired)
+
+	for isin, err := range quoteErrs {
+		if _, found := errs[isin]; !found {
+			errs[isin] = err
+		}
+	}
+
+	return mergeISINs(tickAdded, quoteAdded), mergeISINs(tickRemoved, quoteRemoved), errs
+}
+
+// mergeISINs returns the ISINs of both lists, each once
+func mergeISINs(first, second []string) []string {
+	seen := make(map[string]bool, len(first))
+	merged := make([]string, 0, len(first))
+
+	for _, isin := range append(first, second...) {
+		if !seen[isin] {
+			seen[isin] = true
+			merged = append(merged, isin)
+		}
+	}
+
+	return merged
+}
+
+// GetSubscriptions returns all stored subscriptions
+func (market *MarketDataStream) GetSubscriptions() []string {
+	return market.ticks.GetSubscriptions()
+}
+
+// Disconnect disconnects both streams, see TickStream.Disconnect
+func (market *MarketDataStream) Disconnect() {
+	market.ticks.Disconnect()
+	market.quotes.Disconnect()
+}
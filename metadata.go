@@ -0,0 +1,97 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+// Metadata is arbitrary user data attached to every delivered update, e.g. a strategy ID or a source tag
+type Metadata map[string]string
+
+// SetMetadata sets metadata which is attached to every update of this stream. Subscription metadata set via
+// SetSubscriptionMetadata takes precedence for equal keys.
+func (lms *stream) SetMetadata(metadata Metadata) {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	lms.metadata = copyMetadata(metadata)
+
+	for isin, subscriptionMetadata := range lms.isinMetadata {
+		lms.mergedMetadata[isin] = mergeMetadata(lms.metadata, subscriptionMetadata)
+	}
+}
+
+// SetSubscriptionMetadata sets metadata which is attached to every update of one instrument. The metadata is kept
+// when unsubscribing. Pass nil to remove it.
+func (lms *stream) SetSubscriptionMetadata(isin string, metadata Metadata) {
+	lms.subscriptionsMutex.Lock()
+	defer lms.subscriptionsMutex.Unlock()
+
+	if metadata == nil {
+		delete(lms.isinMetadata, isin)
+		delete(lms.mergedMetadata, isin)
+	} else {
+		lms.isinMetadata[isin] = copyMetadata(metadata)
+		lms.mergedMetadata[isin] = mergeMetadata(lms.metadata, metadata)
+	}
+}
+
+// tag attaches the metadata of the ISIN to the update
+func (lms *stream) tag(isin string, update interface{}) {
+	lms.subscriptionsMutex.Lock()
+	metadata, exists := lms.mergedMetadata[isin]
+
+	if !exists {
+		metadata = lms.metadata
+	}
+	lms.subscriptionsMutex.Unlock()
+
+	switch typed := update.(type) {
+	case *Tick:
+		typed.Metadata = metadata
+
+	case *Quote:
+		typed.Metadata = metadata
+	}
+}
+
+func copyMetadata(metadata Metadata) Metadata {
+	if metadata == nil {
+		return nil
+	}
+
+	return mergeMetadata(nil, metadata)
+}
+
+func mergeMetadata(base, overlay Metadata) Metadata {
+	merged := make(Metadata, len(base)+len(overlay))
+
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range overlay {
+		merged[key] = value
+	}
+
+	return merged
+}
@@ -0,0 +1,154 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// holdTemporarily subscribes the ISIN for a one-shot query unless it's subscribed explicitly. Returns whether the
+// caller holds a temporary subscription and has to release it.
+func (lms *stream) holdTemporarily(isin string) (bool, error) {
+	lms.temporaryMutex.Lock()
+	defer lms.temporaryMutex.Unlock()
+
+	if lms.temporary[isin] == 0 && lms.isSubscribed(isin) {
+		return false, nil
+	}
+
+	lms.temporary[isin]++
+
+	return true, lms.addSubscription(isin)
+}
+
+// releaseTemporarily gives up a temporary subscription. The last holder unsubscribes, unless the ISIN was subscribed
+// explicitly in the meantime.
+func (lms *stream) releaseTemporarily(isin string) {
+	lms.temporaryMutex.Lock()
+	defer lms.temporaryMutex.Unlock()
+
+	holders, temporary := lms.temporary[isin]
+
+	if !temporary {
+		return
+	}
+
+	if holders > 1 {
+		lms.temporary[isin] = holders - 1
+		return
+	}
+
+	delete(lms.temporary, isin)
+	lms.unsubscribe(isin)
+}
+
+// awaitLastValue waits until the ISIN produced an update and returns it. If the ISIN was not subscribed before, it's
+// subscribed for the time of the call only.
+func (lms *stream) awaitLastValue(ctx context.Context, isin string) (interface{}, error) {
+	held, err := lms.holdTemporarily(isin)
+
+	if held {
+		defer lms.releaseTemporarily(isin)
+	}
+
+	if errors.Is(err, ErrQuotaExceeded) {
+		return nil, err
+	}
+
+	// Subscribed above already
+	if err := lms.waitSubscribed(ctx, isin, func(string) error { return nil }); err != nil {
+		return nil, err
+	}
+
+	value, exists := lms.lastValueOf(isin)
+
+	if !exists {
+		if !lms.isSubscribed(isin) {
+			return nil, ErrUnsubscribed
+		}
+
+		// The first update was withheld by the circuit breaker
+		return nil, &SubscriptionError{ISIN: isin, Err: ErrCircuitBreakerTripped}
+	}
+
+	return value.update, nil
+}
+
+// GetPrice returns the current price of an instrument. If the instrument is already subscribed and produced an update
+// the last price is returned right away. Otherwise it's subscribed temporarily until the first tick arrives or the
+// context expires. Keep in mind that the tick is delivered into your update channel as well.
+func (stream *TickStream) GetPrice(ctx context.Context, isin string) (float64, error) {
+	update, err := stream.awaitLastValue(ctx, isin)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return update.(*Tick).Price, nil
+}
+
+// GetSnapshot returns the current quotes of several instruments. Instruments which are not subscribed yet are
+// subscribed temporarily, all at once, until their first quote arrives or the context expires. The returned map
+// contains every quote that could be gathered. If at least one is missing the first error is returned alongside.
+// Duplicate ISINs are queried once. Keep in mind that the quotes are delivered into your update channel as well.
+func (stream *QuoteStream) GetSnapshot(ctx context.Context, isins []string) (map[string]Quote, error) {
+	snapshot := make(map[string]Quote, len(isins))
+	var firstError error
+	mutex := &sync.Mutex{}
+	waitGroup := &sync.WaitGroup{}
+	seen := make(map[string]bool, len(isins))
+
+	for _, isin := range isins {
+		if seen[isin] {
+			continue
+		}
+
+		seen[isin] = true
+		waitGroup.Add(1)
+
+		go func(isin string) {
+			defer waitGroup.Done()
+
+			update, err := stream.awaitLastValue(ctx, isin)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				if firstError == nil {
+					firstError = err
+				}
+			} else {
+				snapshot[isin] = *update.(*Quote)
+			}
+		}(isin)
+	}
+
+	waitGroup.Wait()
+
+	return snapshot, firstError
+}
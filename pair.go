@@ -0,0 +1,137 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// PairUpdate is emitted by a Pair every time one of its legs ticks and both legs have a price.
+type PairUpdate struct {
+	ISIN_A string    // ISIN of the first leg
+	ISIN_B string    // ISIN of the second leg
+	Ratio  float64   // Price of A divided by price of B
+	Spread float64   // Price of A minus price of B
+	ZScore float64   // Z-score of the ratio over the rolling window. 0 until the window has at least two values
+	Time   time.Time // Time of the calculation
+}
+
+// Pair tracks the price ratio and spread between two instruments. The z-score is calculated over a rolling window of
+// the most recent ratios.
+type Pair struct {
+	isinA, isinB   string
+	priceA, priceB float64
+	window         []float64
+	windowSize     int
+	mutex          *sync.Mutex
+}
+
+// NewPair creates a pair for two ISINs. windowSize is the number of ratios used for the z-score.
+func NewPair(isinA, isinB string, windowSize int) *Pair {
+	if windowSize < 2 {
+		windowSize = 2
+	}
+
+	return &Pair{
+		isinA:      isinA,
+		isinB:      isinB,
+		window:     make([]float64, 0, windowSize),
+		windowSize: windowSize,
+		mutex:      &sync.Mutex{}}
+}
+
+// Update feeds a tick into the pair. The second return value is false if the tick belongs to neither leg or one leg
+// has no usable price yet.
+func (pair *Pair) Update(tick *Tick) (*PairUpdate, bool) {
+	pair.mutex.Lock()
+	defer pair.mutex.Unlock()
+
+	switch tick.ISIN {
+	case pair.isinA:
+		pair.priceA = tick.Price
+	case pair.isinB:
+		pair.priceB = tick.Price
+	default:
+		return nil, false
+	}
+
+	if pair.priceA == 0 || pair.priceB == 0 {
+		return nil, false
+	}
+
+	ratio := pair.priceA / pair.priceB
+
+	if len(pair.window) == pair.windowSize {
+		pair.window = pair.window[1:]
+	}
+
+	pair.window = append(pair.window, ratio)
+
+	return &PairUpdate{
+		ISIN_A: pair.isinA,
+		ISIN_B: pair.isinB,
+		Ratio:  ratio,
+		Spread: pair.priceA - pair.priceB,
+		ZScore: zScore(pair.window, ratio),
+		Time:   time.Now()}, true
+}
+
+// Run reads ticks until the tick channel is closed and sends every pair update into the update channel. Keep in
+// mind: You are responsible for both channels.
+func (pair *Pair) Run(ticks <-chan *Tick, updates chan<- *PairUpdate) {
+	for tick := range ticks {
+		if update, ok := pair.Update(tick); ok {
+			updates <- update
+		}
+	}
+}
+
+func zScore(values []float64, value float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+
+	var mean, variance float64
+
+	for _, v := range values {
+		mean += v
+	}
+
+	mean /= float64(len(values))
+
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+
+	deviation := math.Sqrt(variance / float64(len(values)))
+
+	if deviation == 0 {
+		return 0
+	}
+
+	return (value - mean) / deviation
+}
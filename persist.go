@@ -0,0 +1,147 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistedStats is the file format of WithStatsFile
+type persistedStats struct {
+	Messages     uint64 `json:"messages"`
+	Updates      uint64 `json:"updates"`
+	DecodeErrors uint64 `json:"decode_errors"`
+	Errors       uint64 `json:"errors"`
+	Reconnects   uint64 `json:"reconnects"`
+	Drops        uint64 `json:"drops"`
+	UptimeNanos  int64  `json:"uptime_ns"`
+}
+
+// WithStatsFile persists the counters of GetStats to the given file every interval and on Disconnect. The file is read
+// when the stream is created, so the counters keep growing across restarts. A missing file starts from zero, an
+// unreadable one is reported to the error channel. An interval of 0 or less defaults to one minute.
+func WithStatsFile(path string, interval time.Duration) Option {
+	return func(lms *stream) {
+		if interval <= 0 {
+			interval = time.Minute
+		}
+
+		lms.statsFile = path
+		lms.statsInterval = interval
+	}
+}
+
+func (lms *stream) loadStats() {
+	content, err := ioutil.ReadFile(lms.statsFile)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		lms.reportError(err)
+		return
+	}
+
+	var persisted persistedStats
+
+	if err := json.Unmarshal(content, &persisted); err != nil {
+		lms.reportError(err)
+		return
+	}
+
+	lms.statsBase = Stats{
+		Messages:     persisted.Messages,
+		Updates:      persisted.Updates,
+		DecodeErrors: persisted.DecodeErrors,
+		Errors:       persisted.Errors,
+		Reconnects:   persisted.Reconnects,
+		Drops:        persisted.Drops,
+		Uptime:       time.Duration(persisted.UptimeNanos)}
+}
+
+// saveStats writes the counters to a temporary file first, so a crash never leaves a truncated file behind
+func (lms *stream) saveStats() error {
+	stats := lms.GetStats()
+
+	content, err := json.Marshal(persistedStats{
+		Messages:     stats.Messages,
+		Updates:      stats.Updates,
+		DecodeErrors: stats.DecodeErrors,
+		Errors:       stats.Errors,
+		Reconnects:   stats.Reconnects,
+		Drops:        stats.Drops,
+		UptimeNanos:  int64(stats.Uptime)})
+
+	if err != nil {
+		return err
+	}
+
+	temporary, err := ioutil.TempFile(filepath.Dir(lms.statsFile), filepath.Base(lms.statsFile)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temporary.Name())
+
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary.Name(), lms.statsFile)
+}
+
+func (lms *stream) statsWatchdog() {
+	defer lms.workers.Done()
+	defer lms.running("stats watchdog")()
+
+	ticker := time.NewTicker(lms.statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lms.done:
+			if err := lms.saveStats(); err != nil {
+				lms.reportError(err)
+			}
+
+			return
+
+		case <-ticker.C:
+			if err := lms.saveStats(); err != nil {
+				lms.reportError(err)
+			}
+		}
+	}
+}
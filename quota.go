@@ -0,0 +1,164 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"sync"
+	"time"
+)
+
+// quota tracks outbound subscribe and unsubscribe messages in a sliding window
+type quota struct {
+	perMinute int         // Allowed messages per minute. 0 means unlimited
+	perHour   int         // Allowed messages per hour. 0 means unlimited
+	delay     bool        // Wait for free quota instead of rejecting
+	sent      []time.Time // Send times within the last hour, oldest first
+	mutex     *sync.Mutex
+}
+
+// prune removes send times older than an hour. Caller must hold the mutex.
+func (q *quota) prune(now time.Time) {
+	for len(q.sent) > 0 && now.Sub(q.sent[0]) >= time.Hour {
+		q.sent = q.sent[1:]
+	}
+}
+
+// remaining returns the free quota per minute and per hour. -1 means unlimited. Caller must hold the mutex.
+func (q *quota) remaining(now time.Time) (int, int) {
+	q.prune(now)
+
+	minute, hour := -1, -1
+
+	if q.perMinute > 0 {
+		minute = q.perMinute
+
+		for _, sent := range q.sent {
+			if now.Sub(sent) < time.Minute {
+				minute--
+			}
+		}
+
+		if minute < 0 {
+			minute = 0
+		}
+	}
+
+	if q.perHour > 0 {
+		hour = q.perHour - len(q.sent)
+
+		if hour < 0 {
+			hour = 0
+		}
+	}
+
+	return minute, hour
+}
+
+// take reserves one message. Returns 0 on success or the time until the next message is allowed, and whether the
+// caller should wait for it.
+func (q *quota) take(now time.Time) (time.Duration, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.perMinute <= 0 && q.perHour <= 0 {
+		return 0, q.delay
+	}
+
+	minute, hour := q.remaining(now)
+	minuteFree := q.perMinute <= 0 || minute > 0
+	hourFree := q.perHour <= 0 || hour > 0
+
+	if minuteFree && hourFree {
+		q.sent = append(q.sent, now)
+		return 0, q.delay
+	}
+
+	if !hourFree {
+		return time.Hour - now.Sub(q.sent[0]), q.delay
+	}
+
+	for _, sent := range q.sent {
+		if now.Sub(sent) < time.Minute {
+			return time.Minute - now.Sub(sent), q.delay
+		}
+	}
+
+	return time.Second, q.delay
+}
+
+// record counts a message which must be sent regardless of the quota, e.g. resubscriptions after a reconnect
+func (q *quota) record(now time.Time) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.perMinute > 0 || q.perHour > 0 {
+		q.prune(now)
+		q.sent = append(q.sent, now)
+	}
+}
+
+// acquireQuota reserves quota for one message. Depending on the configuration it waits for free quota or returns
+// false right away.
+func (lms *stream) acquireQuota() bool {
+	for {
+		wait, delay := lms.quota.take(time.Now())
+
+		if wait == 0 {
+			return true
+		}
+
+		if !delay {
+			return false
+		}
+
+		select {
+		case <-time.After(wait):
+
+		case <-lms.done:
+			return false
+		}
+	}
+}
+
+// SetQuota limits the number of subscribe and unsubscribe messages sent to lemon.markets per minute and per hour. 0
+// means unlimited. If delay is true Subscribe and Unsubscribe block until quota is available, otherwise the operation
+// is rejected with a SubscriptionError wrapping ErrQuotaExceeded, which Subscribe, Unsubscribe, the batch methods and
+// SetSubscriptions return. Resubscriptions after a reconnect are counted but never delayed or rejected.
+func (lms *stream) SetQuota(perMinute, perHour int, delay bool) {
+	lms.quota.mutex.Lock()
+	defer lms.quota.mutex.Unlock()
+
+	lms.quota.perMinute = perMinute
+	lms.quota.perHour = perHour
+	lms.quota.delay = delay
+}
+
+// GetRemainingQuota returns the free quota for the current minute and hour. -1 means unlimited.
+func (lms *stream) GetRemainingQuota() (int, int) {
+	lms.quota.mutex.Lock()
+	defer lms.quota.mutex.Unlock()
+
+	return lms.quota.remaining(time.Now())
+}
@@ -0,0 +1,135 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+type tickRoute struct {
+	match   func(*Tick) bool
+	channel chan<- *Tick
+}
+
+type quoteRoute struct {
+	match   func(*Quote) bool
+	channel chan<- *Quote
+}
+
+// AddRoute directs every tick for which match returns true into the given channel instead of the update channel.
+// Routes are evaluated in the order they were added and the first matching route wins. match is called on the
+// reading goroutine, so keep it cheap. Keep in mind that you are the one in charge of maintaining and servicing the
+// channel.
+func (stream *TickStream) AddRoute(match func(*Tick) bool, channel chan<- *Tick) {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	stream.routes = append(stream.routes, &tickRoute{match: match, channel: channel})
+}
+
+// ClearRoutes removes all routes. Every tick goes to the update channel again.
+func (stream *TickStream) ClearRoutes() {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	stream.routes = nil
+}
+
+func (stream *TickStream) route(tick *Tick) bool {
+	stream.routesMutex.Lock()
+	routes := stream.routes
+	channel, exists := stream.isinChannels[tick.ISIN]
+	stream.routesMutex.Unlock()
+
+	if exists {
+		select {
+		case channel <- tick:
+
+		case <-stream.done:
+		}
+
+		return true
+	}
+
+	for _, route := range routes {
+		if route.match(tick) {
+			select {
+			case route.channel <- tick:
+
+			case <-stream.done:
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddRoute directs every quote for which match returns true into the given channel instead of the update channel.
+// Routes are evaluated in the order they were added and the first matching route wins. match is called on the
+// reading goroutine, so keep it cheap. Keep in mind that you are the one in charge of maintaining and servicing the
+// channel.
+func (stream *QuoteStream) AddRoute(match func(*Quote) bool, channel chan<- *Quote) {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	stream.routes = append(stream.routes, &quoteRoute{match: match, channel: channel})
+}
+
+// ClearRoutes removes all routes. Every quote goes to the update channel again.
+func (stream *QuoteStream) ClearRoutes() {
+	stream.routesMutex.Lock()
+	defer stream.routesMutex.Unlock()
+
+	stream.routes = nil
+}
+
+func (stream *QuoteStream) route(quote *Quote) bool {
+	stream.routesMutex.Lock()
+	routes := stream.routes
+	channel, exists := stream.isinChannels[quote.ISIN]
+	stream.routesMutex.Unlock()
+
+	if exists {
+		select {
+		case channel <- quote:
+
+		case <-stream.done:
+		}
+
+		return true
+	}
+
+	for _, route := range routes {
+		if route.match(quote) {
+			select {
+			case route.channel <- quote:
+
+			case <-stream.done:
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
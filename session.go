@@ -0,0 +1,127 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"sync"
+	"time"
+)
+
+// SessionType is the kind of trading session
+type SessionType int
+
+const (
+	Session_closed  SessionType = iota // Outside of the opening hours
+	Session_regular                    // Regular weekday hours
+	Session_weekend                    // Short Saturday and Sunday sessions with thin liquidity
+)
+
+// String returns a human readable representation of the session type
+func (session SessionType) String() string {
+	switch session {
+	case Session_closed:
+		return "closed"
+
+	case Session_regular:
+		return "regular"
+
+	case Session_weekend:
+		return "weekend"
+	}
+
+	return "unknown"
+}
+
+var (
+	berlinLocation *time.Location
+	berlinOnce     sync.Once
+)
+
+// berlin returns the time zone of the exchange. Falls back to UTC if the time zone database is not available.
+func berlin() *time.Location {
+	berlinOnce.Do(func() {
+		location, err := time.LoadLocation("Europe/Berlin")
+
+		if err != nil {
+			location = time.UTC
+		}
+
+		berlinLocation = location
+	})
+
+	return berlinLocation
+}
+
+func sessionAt(now time.Time) SessionType {
+	local := now.In(berlin())
+
+	if !isExchangeOpen(local) {
+		return Session_closed
+	}
+
+	if weekday := local.Weekday(); weekday == time.Saturday || weekday == time.Sunday {
+		return Session_weekend
+	}
+
+	return Session_regular
+}
+
+// CurrentSession returns the trading session Lang und Schwarz Tradecenter is currently in
+func CurrentSession() SessionType {
+	return sessionAt(time.Now())
+}
+
+// WithWeekendMode tunes the stream for the weekend sessions. Updates received during a weekend session are flagged as
+// indicative and the idle timeout and consistency tolerance are multiplied with factor.
+func WithWeekendMode(factor float64) Option {
+	return func(lms *stream) {
+		lms.weekendFactor = factor
+	}
+}
+
+// widen returns the threshold to apply in the given session
+func (lms *stream) widen(session SessionType, threshold float64) float64 {
+	if session == Session_weekend && lms.weekendFactor > 0 {
+		return threshold * lms.weekendFactor
+	}
+
+	return threshold
+}
+
+// tagSession sets the session of an update and flags it as indicative if weekend mode applies
+func (lms *stream) tagSession(update interface{}, now time.Time) {
+	session := sessionAt(now)
+	indicative := session == Session_weekend && lms.weekendFactor > 0
+
+	switch typed := update.(type) {
+	case *Tick:
+		typed.Session = session
+		typed.Indicative = indicative
+
+	case *Quote:
+		typed.Session = session
+		typed.Indicative = indicative
+	}
+}
@@ -0,0 +1,146 @@
+//go:build soak
+// +build soak
+
+package lemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Run with: go test -tags soak -run Soak -timeout 0 . Set LEMON_SOAK_DURATION (e.g. 3h) to change the default of 10m.
+
+// newStreamingServer starts a WebSocket server which sends a tick for every subscription every few milliseconds and
+// drops each connection after dropAfter
+func newStreamingServer(dropAfter time.Duration) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer connection.Close()
+
+		subscriptions := make(chan string, 100)
+
+		go func() {
+			for {
+				var subscription lemonMarketSubscription
+
+				if err := connection.ReadJSON(&subscription); err != nil {
+					close(subscriptions)
+					return
+				}
+
+				if subscription.Action == "subscribe" {
+					subscriptions <- subscription.ISIN
+				}
+			}
+		}()
+
+		isins := make([]string, 0)
+		ticker := time.NewTicker(time.Millisecond * 5)
+		defer ticker.Stop()
+		drop := time.After(dropAfter)
+
+		for {
+			select {
+			case isin, open := <-subscriptions:
+				if !open {
+					return
+				}
+
+				isins = append(isins, isin)
+
+			case <-ticker.C:
+				for _, isin := range isins {
+					message := `{"isin":"` + isin + `","price":1.5,"quantity":2}`
+
+					if connection.WriteMessage(websocket.TextMessage, []byte(message)) != nil {
+						return
+					}
+				}
+
+			case <-drop:
+				return
+			}
+		}
+	}))
+}
+
+func soakMeasure() (int, uint64) {
+	runtime.GC()
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	return runtime.NumGoroutine(), memory.HeapAlloc
+}
+
+func TestSoak(t *testing.T) {
+	duration := time.Minute * 10
+
+	if value := os.Getenv("LEMON_SOAK_DURATION"); value != "" {
+		parsed, err := time.ParseDuration(value)
+
+		if err != nil {
+			t.Fatalf("Invalid LEMON_SOAK_DURATION: %s", err)
+		}
+
+		duration = parsed
+	}
+
+	server := newStreamingServer(time.Second * 20)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	stream := NewManagedTickStream(100, WithURL(url), WithBackoff(func(int) time.Duration { return 0 }))
+	defer stream.Disconnect()
+
+	go func() {
+		for range stream.Errors() {
+		}
+	}()
+
+	go func() {
+		for range stream.Updates() {
+		}
+	}()
+
+	stream.SubscribeAll([]string{"DE000TUAG000", "LS000IGOLD01", "US00165C1045"})
+
+	// Let the first reconnect happen before taking the baseline
+	time.Sleep(time.Second * 30)
+	baseGoroutines, baseHeap := soakMeasure()
+	deadline := time.Now().Add(duration)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Minute)
+
+		goroutines, heap := soakMeasure()
+		t.Logf("Goroutines: %d (base %d), heap: %d (base %d), stats: %+v", goroutines, baseGoroutines, heap,
+			baseHeap, stream.GetStats())
+
+		if goroutines > baseGoroutines+2 {
+			t.Fatalf("Goroutine leak: %d goroutines, baseline %d", goroutines, baseGoroutines)
+		}
+
+		if heap > baseHeap*2+1<<20 {
+			t.Fatalf("Memory growth: heap %d bytes, baseline %d", heap, baseHeap)
+		}
+	}
+
+	if stream.GetStats().Reconnects == 0 {
+		t.Fatal("No reconnects happened during the soak test")
+	}
+}
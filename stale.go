@@ -0,0 +1,88 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WithStaleTimeout forces a reconnect if no message was received for the given duration while the exchange is open
+// and at least one instrument is subscribed. ErrStreamStale is sent into the error channel. This catches half-open
+// connections which stop delivering without an error. With WithWeekendMode the timeout is widened during weekend
+// sessions.
+func WithStaleTimeout(timeout time.Duration) Option {
+	return func(lms *stream) {
+		lms.staleTimeout = timeout
+	}
+}
+
+func (lms *stream) staleWatchdog() {
+	defer lms.workers.Done()
+	defer lms.running("stale watchdog")()
+
+	ticker := time.NewTicker(lms.staleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lms.done:
+			return
+
+		case now := <-ticker.C:
+			if lms.isStale(now) {
+				lms.closeStaleConnection()
+			}
+		}
+	}
+}
+
+func (lms *stream) isStale(now time.Time) bool {
+	if lms.GetState() != State_connected || len(lms.GetSubscriptions()) == 0 {
+		return false
+	}
+
+	session := sessionAt(now)
+
+	if session == Session_closed {
+		return false
+	}
+
+	timeout := time.Duration(lms.widen(session, float64(lms.staleTimeout)))
+	lastMessage := time.Unix(0, atomic.LoadInt64(&lms.lastMessage))
+
+	return now.Sub(lastMessage) >= timeout
+}
+
+// closeStaleConnection closes the connection, so listen reports ErrStreamStale and requests a reconnect
+func (lms *stream) closeStaleConnection() {
+	lms.writeMutex.Lock()
+	defer lms.writeMutex.Unlock()
+
+	if lms.connection != nil && atomic.LoadInt32(&lms.listening) == 1 {
+		atomic.StoreInt32(&lms.stale, 1)
+		lms.connection.Close()
+	}
+}
@@ -0,0 +1,83 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"expvar"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the internal counters of a stream
+type Stats struct {
+	Messages     uint64        // Messages read from the WebSocket
+	Updates      uint64        // Updates delivered to the update channel
+	DecodeErrors uint64        // Messages which could not be decoded
+	Errors       uint64        // Errors reported to the error channel
+	Reconnects   uint64        // Reconnect attempts
+	Drops        uint64        // Updates which were dropped instead of delivered
+	Uptime       time.Duration // Time since the stream was created
+	Goroutines   int           // Goroutines of the whole process
+}
+
+// counters are updated atomically
+type counters struct {
+	messages     uint64
+	updates      uint64
+	decodeErrors uint64
+	errors       uint64
+	reconnects   uint64
+	drops        uint64
+	policyDrops  uint64 // Subset of drops caused by the drop policy or the maximum delivery age
+}
+
+func (c *counters) count(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
+
+// GetStats returns a snapshot of the internal counters. With WithStatsFile the counters and the uptime include the
+// values persisted by earlier runs.
+func (lms *stream) GetStats() Stats {
+	base := lms.statsBase
+
+	return Stats{
+		Messages:     base.Messages + atomic.LoadUint64(&lms.stats.messages),
+		Updates:      base.Updates + atomic.LoadUint64(&lms.stats.updates),
+		DecodeErrors: base.DecodeErrors + atomic.LoadUint64(&lms.stats.decodeErrors),
+		Errors:       base.Errors + atomic.LoadUint64(&lms.stats.errors),
+		Reconnects:   base.Reconnects + atomic.LoadUint64(&lms.stats.reconnects),
+		Drops:        base.Drops + atomic.LoadUint64(&lms.stats.drops),
+		Uptime:       base.Uptime + time.Since(lms.created),
+		Goroutines:   runtime.NumGoroutine()}
+}
+
+// PublishExpvar publishes the counters of GetStats via expvar under the given name, so they show up on /debug/vars.
+// Like expvar.Publish it panics if the name is already in use.
+func (lms *stream) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return lms.GetStats()
+	}))
+}
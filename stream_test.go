@@ -0,0 +1,2022 @@
+package lemon
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newMockServer starts a WebSocket server which answers every subscription with the given number of ticks
+func newMockServer(t *testing.T, ticksPerSubscription int) (*httptest.Server, string) {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			t.Errorf("Upgrade failed: %s", err)
+			return
+		}
+
+		defer connection.Close()
+
+		for {
+			var subscription lemonMarketSubscription
+
+			if err := connection.ReadJSON(&subscription); err != nil {
+				return
+			}
+
+			if subscription.Action != "subscribe" {
+				continue
+			}
+
+			for i := 0; i < ticksPerSubscription; i++ {
+				message := `{"isin":"` + subscription.ISIN + `","price":1.5,"quantity":2}`
+
+				if err := connection.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+					return
+				}
+			}
+		}
+	}))
+
+	return server, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestTickStream(t *testing.T) {
+	server, url := newMockServer(t, 3)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(url))
+
+	if state := stream.GetState(); state != State_connected {
+		t.Fatalf("Expected state %s, got %s", State_connected, state)
+	}
+
+	stream.Subscribe("DE000TUAG000")
+
+	for i := 0; i < 3; i++ {
+		select {
+		case tick := <-stream.Updates():
+			if tick.ISIN != "DE000TUAG000" || tick.Price != 1.5 || tick.Quantity != 2 || tick.ReceivedAt.IsZero() {
+				t.Fatalf("Unexpected tick: %+v", tick)
+			}
+
+		case err := <-stream.Errors():
+			t.Fatalf("Unexpected error: %s", err)
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for ticks")
+		}
+	}
+
+	stream.Disconnect()
+
+	if state := stream.GetState(); state != State_disconnected {
+		t.Fatalf("Expected state %s, got %s", State_disconnected, state)
+	}
+
+	if _, open := <-stream.Updates(); open {
+		t.Fatal("Update channel still open after Disconnect")
+	}
+}
+
+func TestMaxReconnects(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	server.Close()
+
+	noBackoff := func(int) time.Duration {
+		return 0
+	}
+
+	stream := NewManagedTickStream(10, WithURL(url), WithBackoff(noBackoff), WithMaxReconnects(2))
+	failures := 0
+
+	for err := range stream.Errors() {
+		if err == ErrReconnectsExhausted {
+			break
+		} else if err != ErrConnectFailed {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		failures++
+	}
+
+	// The initial connect plus two reconnects
+	if failures != 3 {
+		t.Fatalf("Expected 3 failed connects, got %d", failures)
+	}
+
+	if state := stream.GetState(); state != State_reconnects_exhausted {
+		t.Fatalf("Expected state %s, got %s", State_reconnects_exhausted, state)
+	}
+
+	stream.Disconnect()
+}
+
+func TestSetSubscriptions(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(url))
+	defer stream.Disconnect()
+
+	stream.Subscribe("A")
+	stream.Subscribe("B")
+
+	added, removed, errs := stream.SetSubscriptions([]string{"B", "C"})
+
+	if len(added) != 1 || added[0] != "C" || len(removed) != 1 || removed[0] != "A" || len(errs) != 0 {
+		t.Fatalf("Unexpected diff. Added: %v, Removed: %v, Errors: %v", added, removed, errs)
+	}
+
+	if subscriptions := stream.GetSubscriptions(); len(subscriptions) != 2 {
+		t.Fatalf("Expected 2 subscriptions, got %v", subscriptions)
+	}
+}
+
+func TestSetSubscriptionsQuota(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(url))
+	defer stream.Disconnect()
+
+	stream.SetQuota(1, 0, false)
+	added, _, errs := stream.SetSubscriptions([]string{"A", "B", "C"})
+
+	if len(added) != 1 || len(errs) != 2 {
+		t.Fatalf("Expected one added ISIN and two errors. Added: %v, Errors: %v", added, errs)
+	}
+
+	for isin, err := range errs {
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("Expected %s for %s, got %s", ErrQuotaExceeded, isin, err)
+		}
+	}
+
+	if subscriptions := stream.GetSubscriptions(); len(subscriptions) != 1 || subscriptions[0] != added[0] {
+		t.Fatalf("Expected subscriptions %v, got %v", added, subscriptions)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	connections := int32(0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer connection.Close()
+
+		if atomic.AddInt32(&connections, 1) == 1 {
+			// Drop the first connection right away
+			return
+		}
+
+		for {
+			if _, _, err := connection.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	connects := make(chan string, 10)
+	disconnects := make(chan error, 10)
+	reconnects := make(chan int, 10)
+
+	stream := NewManagedTickStream(10,
+		WithURL("ws"+strings.TrimPrefix(server.URL, "http")),
+		WithBackoff(func(int) time.Duration { return 0 }),
+		WithOnConnect(func(endpoint string) { connects <- endpoint }),
+		WithOnDisconnect(func(err error) { disconnects <- err }),
+		WithOnReconnect(func(attempts int, downtime time.Duration) { reconnects <- attempts }))
+	defer stream.Disconnect()
+
+	go func() {
+		for range stream.Errors() {
+		}
+	}()
+
+	select {
+	case attempts := <-reconnects:
+		if attempts != 1 {
+			t.Fatalf("Expected 1 attempt, got %d", attempts)
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for the reconnect")
+	}
+
+	if len(connects) != 2 || len(disconnects) != 1 {
+		t.Fatalf("Expected 2 connects and 1 disconnect, got %d and %d", len(connects), len(disconnects))
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	server, url := newMockServer(t, 1)
+	defer server.Close()
+
+	routed := make(chan *Tick, 10)
+	stream := NewManagedTickStream(10, WithURL(url))
+	defer stream.Disconnect()
+
+	stream.AddRoute(func(tick *Tick) bool { return tick.ISIN == "A" }, routed)
+	stream.Subscribe("A")
+	stream.Subscribe("B")
+
+	for _, expected := range []struct {
+		channel <-chan *Tick
+		isin    string
+	}{{routed, "A"}, {stream.Updates(), "B"}} {
+		select {
+		case tick := <-expected.channel:
+			if tick.ISIN != expected.isin {
+				t.Fatalf("Expected tick for %s, got %s", expected.isin, tick.ISIN)
+			}
+
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Timeout waiting for %s", expected.isin)
+		}
+	}
+}
+
+func TestSubscribeAll(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(url))
+	defer stream.Disconnect()
+
+	stream.SetQuota(2, 0, false)
+	results := stream.SubscribeAll([]string{"A", "B", "C", "A"})
+
+	if len(results) != 3 || results["A"] != nil || results["B"] != nil {
+		t.Fatalf("Unexpected results: %v", results)
+	}
+
+	if !errors.Is(results["C"], ErrQuotaExceeded) {
+		t.Fatalf("Expected C to exceed the quota, got %v", results["C"])
+	}
+}
+
+func TestSubscribeNotConnected(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(url))
+	defer stream.Disconnect()
+
+	if err := stream.Subscribe("A"); err != ErrNotConnected {
+		t.Fatalf("Expected ErrNotConnected, got %v", err)
+	}
+
+	if subscriptions := stream.GetSubscriptions(); len(subscriptions) != 1 {
+		t.Fatalf("Expected the subscription to stay registered, got %v", subscriptions)
+	}
+}
+
+func TestAuthProvider(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_id") != "id" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte(`{"access_token":"secret","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	upgrader := websocket.Upgrader{}
+	authorization := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case authorization <- r.Header.Get("Authorization"):
+
+		default:
+		}
+
+		if connection, err := upgrader.Upgrade(w, r, nil); err == nil {
+			connection.Close()
+		}
+	}))
+	defer server.Close()
+
+	provider := NewClientCredentials(tokenServer.URL, "id", "password")
+	stream := NewManagedTickStream(10, WithURL("ws"+strings.TrimPrefix(server.URL, "http")),
+		WithAuthProvider(provider), WithMaxReconnects(1))
+	defer stream.Disconnect()
+
+	if header := <-authorization; header != "Bearer secret" {
+		t.Fatalf("Expected bearer token, got %q", header)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	var tunnels int32
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		defer upstream.Close()
+
+		client, _, err := w.(http.Hijacker).Hijack()
+
+		if err != nil {
+			return
+		}
+
+		defer client.Close()
+
+		atomic.AddInt32(&tunnels, 1)
+		client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		go io.Copy(upstream, client)
+		io.Copy(client, upstream)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	stream := NewManagedTickStream(10, WithURL(wsURL), WithProxy(proxyURL))
+	defer stream.Disconnect()
+
+	stream.Subscribe("DE000TUAG000")
+
+	select {
+	case <-stream.Updates():
+
+	case err := <-stream.Errors():
+		t.Fatalf("Unexpected error: %s", err)
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for tick")
+	}
+
+	if atomic.LoadInt32(&tunnels) != 1 {
+		t.Fatalf("Expected one tunnel, got %d", tunnels)
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	var states, connects, updates int32
+
+	bus := NewEventBus()
+	bus.Handle(func(event Event) {
+		switch event.(type) {
+		case *StateEvent:
+			atomic.AddInt32(&states, 1)
+
+		case *ConnectEvent:
+			atomic.AddInt32(&connects, 1)
+
+		case *UpdateEvent:
+			atomic.AddInt32(&updates, 1)
+		}
+	})
+
+	stream := NewManagedTickStream(10, WithURL(wsURL), WithEventBus(bus))
+	stream.Subscribe("DE000TUAG000")
+
+	select {
+	case <-stream.Updates():
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for tick")
+	}
+
+	stream.Disconnect()
+
+	// connecting, connected and disconnected
+	if atomic.LoadInt32(&states) != 3 || atomic.LoadInt32(&connects) != 1 || atomic.LoadInt32(&updates) != 1 {
+		t.Fatalf("Unexpected events: %d states, %d connects, %d updates", states, connects, updates)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if connection, err := upgrader.Upgrade(w, r, nil); err == nil {
+			connection.ReadMessage()
+			connection.Close()
+		}
+	}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	wsURL := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	stream := NewManagedTickStream(10, WithURL(wsURL), WithTLSConfig(&tls.Config{RootCAs: roots}))
+
+	if state := stream.GetState(); state != State_connected {
+		t.Fatalf("Expected state %s, got %s", State_connected, state)
+	}
+
+	stream.Disconnect()
+
+	untrusted := NewManagedTickStream(10, WithURL(wsURL), WithTLSConfig(&tls.Config{}), WithMaxReconnects(1))
+	defer untrusted.Disconnect()
+
+	if state := untrusted.GetState(); state == State_connected {
+		t.Fatal("Connected although the certificate is not trusted")
+	}
+}
+
+func TestStatsFile(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	if err := ioutil.WriteFile(path, []byte(`{"messages":5,"updates":3,"uptime_ns":60000000000}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := NewManagedTickStream(10, WithURL(wsURL), WithStatsFile(path, time.Hour))
+	stream.Subscribe("DE000TUAG000")
+
+	select {
+	case <-stream.Updates():
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for tick")
+	}
+
+	stream.Disconnect()
+
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var persisted persistedStats
+
+	if err := json.Unmarshal(content, &persisted); err != nil {
+		t.Fatal(err)
+	}
+
+	if persisted.Messages != 6 || persisted.Updates != 4 || persisted.UptimeNanos <= int64(time.Minute) {
+		t.Fatalf("Unexpected persisted stats: %+v", persisted)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	release := make(chan struct{})
+	defer close(release)
+
+	// The server never reads, so pings are never answered
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if connection, err := upgrader.Upgrade(w, r, nil); err == nil {
+			<-release
+			connection.Close()
+		}
+	}))
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL("ws"+strings.TrimPrefix(server.URL, "http")),
+		WithKeepalive(time.Millisecond*50, time.Millisecond*50))
+	defer stream.Disconnect()
+
+	select {
+	case err := <-stream.Errors():
+		if err != ErrPongTimeout {
+			t.Fatalf("Expected %s, got %s", ErrPongTimeout, err)
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for pong timeout")
+	}
+}
+
+func TestDisconnectDrains(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	// The server is busy for a moment before answering the close frame and sends more ticks meanwhile
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer connection.Close()
+
+		var subscription lemonMarketSubscription
+
+		if err := connection.ReadJSON(&subscription); err != nil {
+			return
+		}
+
+		message := []byte(`{"isin":"` + subscription.ISIN + `","price":1.5,"quantity":2}`)
+		connection.WriteMessage(websocket.TextMessage, message)
+		time.Sleep(time.Millisecond * 200)
+
+		for i := 1; i < 50; i++ {
+			connection.WriteMessage(websocket.TextMessage, message)
+		}
+
+		connection.ReadMessage()
+	}))
+	defer server.Close()
+
+	stream := NewManagedTickStream(0, WithURL("ws"+strings.TrimPrefix(server.URL, "http")))
+	stream.Subscribe("DE000TUAG000")
+
+	received := 0
+
+	select {
+	case <-stream.Updates():
+		received++
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for tick")
+	}
+
+	go stream.Disconnect()
+
+	for range stream.Updates() {
+		received++
+	}
+
+	if received != 50 {
+		t.Fatalf("Expected all 50 ticks sent before the close to be delivered, got %d", received)
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	server, wsURL := newMockServer(t, 50)
+	defer server.Close()
+
+	stream := NewManagedTickStream(0, WithURL(wsURL), WithRingBuffer(64, Drop_none))
+	defer stream.Disconnect()
+
+	stream.Subscribe("DE000TUAG000")
+
+	// Nobody reads yet, but the read loop must not be blocked by that
+	deadline := time.Now().Add(time.Second * 5)
+
+	for stream.GetStats().Messages < 50 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Read loop blocked after %d messages", stream.GetStats().Messages)
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case <-stream.Updates():
+
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Timeout waiting for tick %d", i)
+		}
+	}
+
+	if dropped := stream.DroppedUpdates(); dropped != 0 {
+		t.Fatalf("Expected no drops, got %d", dropped)
+	}
+}
+
+func TestOnTick(t *testing.T) {
+	server, wsURL := newMockServer(t, 3)
+	defer server.Close()
+
+	var handled int32
+	done := make(chan struct{})
+
+	stream := NewTickStream(nil, nil, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	stream.OnTick(func(tick *Tick) {
+		if atomic.AddInt32(&handled, 1) == 3 {
+			close(done)
+		}
+	}, 4)
+
+	stream.Subscribe("DE000TUAG000")
+
+	select {
+	case <-done:
+
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Timeout waiting for handlers, %d ticks handled", atomic.LoadInt32(&handled))
+	}
+}
+
+func TestMarketDataStream(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	events := make(chan Event, 10)
+	market := NewMarketDataStream(events, nil, []Option{WithURL(wsURL)}, []Option{WithURL(wsURL)})
+	defer market.Disconnect()
+
+	if err := market.Subscribe("DE000TUAG000"); err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+
+	ticks, quotes := 0, 0
+
+	for ticks+quotes < 2 {
+		select {
+		case event := <-events:
+			switch typed := event.(type) {
+			case *TickEvent:
+				ticks++
+
+			case *QuoteEvent:
+				if typed.Quote.ISIN != "DE000TUAG000" {
+					t.Fatalf("Unexpected quote: %+v", typed.Quote)
+				}
+
+				quotes++
+			}
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for events")
+		}
+	}
+
+	if ticks != 1 || quotes != 1 {
+		t.Fatalf("Expected one tick and one quote, got %d and %d", ticks, quotes)
+	}
+
+	if subscriptions := market.Quotes().GetSubscriptions(); len(subscriptions) != 1 {
+		t.Fatalf("Expected the quote stream to share the subscription, got %v", subscriptions)
+	}
+}
+
+func TestMarketDataStreamSetSubscriptions(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	events := make(chan Event, 10)
+	market := NewMarketDataStream(events, nil, []Option{WithURL(wsURL)}, []Option{WithURL(wsURL)})
+	defer market.Disconnect()
+
+	market.Ticks().Subscribe("A")
+	market.Quotes().SetQuota(1, 0, false)
+	market.Quotes().Subscribe("C")
+
+	added, removed, errs := market.SetSubscriptions([]string{"B"})
+
+	if len(added) != 1 || added[0] != "B" {
+		t.Fatalf("Expected B to be added on the tick stream, got %v", added)
+	}
+
+	if len(removed) != 1 || removed[0] != "A" {
+		t.Fatalf("Expected A to be removed, got %v", removed)
+	}
+
+	if len(errs) != 2 || !errors.Is(errs["B"], ErrQuotaExceeded) || !errors.Is(errs["C"], ErrQuotaExceeded) {
+		t.Fatalf("Expected the quote stream to reject B and C, got %v", errs)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "feed.sock"))
+
+	if err != nil {
+		t.Skipf("Unix sockets not available: %s", err)
+	}
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	served := make(chan error, 1)
+
+	go func() {
+		served <- stream.ServeFeed(listener)
+	}()
+
+	client, err := net.Dial("unix", listener.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	ticks := make(chan *Tick, 10)
+	go ReadTickFeed(client, ticks)
+
+	// Wait for the client to be attached before subscribing
+	for {
+		stream.teesMutex.Lock()
+		attached := len(stream.tees)
+		stream.teesMutex.Unlock()
+
+		if attached == 1 {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	stream.Subscribe("DE000TUAG000")
+
+	select {
+	case tick := <-ticks:
+		if tick.ISIN != "DE000TUAG000" || tick.Price != 1.5 {
+			t.Fatalf("Unexpected tick: %+v", tick)
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for tick from the feed")
+	}
+
+	stream.Disconnect()
+
+	if err := <-served; err != nil {
+		t.Fatalf("ServeFeed failed: %s", err)
+	}
+}
+
+func TestChannel(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	channel := stream.Channel("DE000TUAG000")
+
+	if stream.Channel("DE000TUAG000") != channel {
+		t.Fatal("Expected the same channel for the same ISIN")
+	}
+
+	stream.SubscribeAll([]string{"DE000TUAG000", "LS000IGOLD01"})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case tick := <-channel:
+			if tick.ISIN != "DE000TUAG000" {
+				t.Fatalf("Unexpected tick in ISIN channel: %+v", tick)
+			}
+
+		case tick := <-stream.Updates():
+			if tick.ISIN != "LS000IGOLD01" {
+				t.Fatalf("Unexpected tick in update channel: %+v", tick)
+			}
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for ticks")
+		}
+	}
+
+	stream.Disconnect()
+
+	if _, open := <-channel; open {
+		t.Fatal("ISIN channel still open after Disconnect")
+	}
+}
+
+func TestSubscribers(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	first := make(chan *Tick, 10)
+	second := make(chan *Tick, 10)
+	stream.AddSubscriber(first)
+	stream.AddSubscriber(second)
+	stream.Subscribe("DE000TUAG000")
+
+	for _, channel := range []chan *Tick{stream.updates, first, second} {
+		select {
+		case <-channel:
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for tick")
+		}
+	}
+
+	stream.RemoveSubscriber(second)
+	stream.Subscribe("LS000IGOLD01")
+
+	<-stream.Updates()
+	<-first
+
+	if len(second) != 0 {
+		t.Fatal("Removed subscriber still receives ticks")
+	}
+}
+
+func TestMaxDeliveryAge(t *testing.T) {
+	server, wsURL := newMockServer(t, 5)
+	defer server.Close()
+
+	stream := NewManagedTickStream(0, WithURL(wsURL), WithMaxDeliveryAge(time.Millisecond*50))
+	defer stream.Disconnect()
+
+	stream.Subscribe("DE000TUAG000")
+
+	// The first tick is handed over right away, the others expire while nobody reads
+	time.Sleep(time.Millisecond * 300)
+
+	select {
+	case <-stream.Updates():
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for tick")
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+
+	for stream.DroppedUpdates() < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if dropped := stream.DroppedUpdates(); dropped != 4 {
+		t.Fatalf("Expected 4 expired ticks, got %d", dropped)
+	}
+
+	select {
+	case tick := <-stream.Updates():
+		t.Fatalf("Expired tick delivered: %+v", tick)
+
+	default:
+	}
+}
+
+func TestGetLastTick(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	if _, _, exists := stream.GetLastTick("DE000TUAG000"); exists {
+		t.Fatal("Last tick of an unsubscribed instrument")
+	}
+
+	stream.Subscribe("DE000TUAG000")
+
+	select {
+	case <-stream.Updates():
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for tick")
+	}
+
+	tick, received, exists := stream.GetLastTick("DE000TUAG000")
+
+	if !exists || tick.Price != 1.5 || received.IsZero() {
+		t.Fatalf("Unexpected last tick: %+v at %s", tick, received)
+	}
+
+	stream.Unsubscribe("DE000TUAG000")
+
+	if _, _, exists := stream.GetLastTick("DE000TUAG000"); exists {
+		t.Fatal("Last tick kept after unsubscribing")
+	}
+}
+
+func TestGetPriceWithFastPath(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	var called int32
+
+	stream := NewManagedTickStream(10, WithURL(wsURL), WithTickFastPath(func(*Tick) {
+		atomic.AddInt32(&called, 1)
+	}))
+	defer stream.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	price, err := stream.GetPrice(ctx, "DE000TUAG000")
+
+	if err != nil || price != 1.5 {
+		t.Fatalf("Expected price 1.5, got %f (error: %v)", price, err)
+	}
+
+	// GetPrice may return before the callback ran
+	deadline := time.Now().Add(time.Second * 5)
+
+	for atomic.LoadInt32(&called) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if calls := atomic.LoadInt32(&called); calls != 1 {
+		t.Fatalf("Expected the fast path to be called once, got %d", calls)
+	}
+}
+
+func TestUnsubscribeQuota(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(url))
+	defer stream.Disconnect()
+
+	stream.SetQuota(1, 0, false)
+	stream.Subscribe("A")
+
+	if err := stream.Unsubscribe("A"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %s, got %v", ErrQuotaExceeded, err)
+	}
+
+	if !stream.isSubscribed("A") {
+		t.Fatal("Rejected unsubscribe removed the subscription")
+	}
+}
+
+func TestSyncUniverseOnce(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(url))
+	defer stream.Disconnect()
+
+	var fetches int32
+
+	stream.SyncUniverse(UniverseFetcherFunc(func() ([]string, error) {
+		atomic.AddInt32(&fetches, 1)
+		return []string{"A", "B"}, nil
+	}), 0)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !stream.isSubscribed("A") || !stream.isSubscribed("B") {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the universe to be subscribed")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	if fetches := atomic.LoadInt32(&fetches); fetches != 1 {
+		t.Fatalf("Expected one fetch, got %d", fetches)
+	}
+}
+
+func TestRunCalendarDefaultInterval(t *testing.T) {
+	var sessions int32
+
+	bus := NewEventBus()
+	bus.Handle(func(event Event) {
+		if _, ok := event.(*SessionEvent); ok {
+			atomic.AddInt32(&sessions, 1)
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	bus.RunCalendar(ctx, 0)
+
+	if sessions := atomic.LoadInt32(&sessions); sessions != 1 {
+		t.Fatalf("Expected one session event, got %d", sessions)
+	}
+}
+
+func TestStreamWithContext(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := NewTickStreamWithContext(ctx, make(chan *Tick, 10), nil, WithURL(url))
+	stream.SetIdleTimeout(time.Minute)
+
+	cancel()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !stream.isDone() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the context to disconnect the stream")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	finished := make(chan struct{})
+
+	go func() {
+		stream.workers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+
+	case <-time.After(time.Second * 10):
+		t.Fatal("Timeout waiting for the background goroutines to stop")
+	}
+}
+
+func TestSubscribeAndWaitUnsubscribed(t *testing.T) {
+	server, url := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(url))
+	defer stream.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if err := stream.SubscribeAndWait(ctx, "A"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected %s, got %v", context.DeadlineExceeded, err)
+	}
+
+	stream.subscriptionsMutex.Lock()
+	waiting := len(stream.waiters["A"])
+	stream.subscriptionsMutex.Unlock()
+
+	if waiting != 0 {
+		t.Fatalf("Expected the expired waiter to be removed, %d left", waiting)
+	}
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- stream.SubscribeAndWait(context.Background(), "B")
+	}()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !stream.isSubscribed("B") {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the subscription")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	stream.Unsubscribe("B")
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrUnsubscribed) {
+			t.Fatalf("Expected %s, got %v", ErrUnsubscribed, err)
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("SubscribeAndWait still blocked after unsubscribe")
+	}
+}
+
+func TestLastValueSkipsWithheldUpdates(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	stream.Subscribe("A")
+	stream.SetCircuitBreaker(10, time.Minute, 3)
+
+	stream.dispatch(&Tick{ISIN: "A", Price: 100})
+	stream.dispatch(&Tick{ISIN: "A", Price: 200})
+
+	if tick, _, exists := stream.GetLastTick("A"); !exists || tick.Price != 100 {
+		t.Fatalf("Expected the last accepted tick, got %+v", tick)
+	}
+
+	stream.dispatch(&Tick{ISIN: "B", Price: 100})
+
+	if _, _, exists := stream.GetLastTick("B"); exists {
+		t.Fatal("Last tick stored for an unsubscribed instrument")
+	}
+}
+
+func TestDumpWorkers(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL), WithDropPolicy(Drop_oldest))
+	defer stream.Disconnect()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for stream.GetState() != State_connected {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the connection")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	var report strings.Builder
+
+	if err := stream.Dump(&report); err != nil {
+		t.Fatalf("Dump failed: %s", err)
+	}
+
+	for _, expected := range []string{"reconnect watchdog: 1", "delivery: 1", "Delivery queue:     0/"} {
+		if !strings.Contains(report.String(), expected) {
+			t.Fatalf("Expected %q in the report:\n%s", expected, report.String())
+		}
+	}
+}
+
+func TestEndpointFailover(t *testing.T) {
+	first, firstURL := newMockServer(t, 0)
+	first.Close()
+
+	second, secondURL := newMockServer(t, 0)
+	second.Close()
+
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	var dialsMutex sync.Mutex
+	dials := make([]string, 0)
+
+	dial := func(network, address string) (net.Conn, error) {
+		dialsMutex.Lock()
+		dials = append(dials, address)
+		dialsMutex.Unlock()
+
+		return net.Dial(network, address)
+	}
+
+	noBackoff := func(int) time.Duration {
+		return 0
+	}
+
+	stream := NewManagedTickStream(10, WithEndpoints(firstURL, secondURL, wsURL), WithNetDial(dial),
+		WithBackoff(noBackoff))
+	defer stream.Disconnect()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for stream.GetState() != State_connected {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the failover")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if endpoint := stream.GetEndpoint(); endpoint != wsURL {
+		t.Fatalf("Expected endpoint %s, got %s", wsURL, endpoint)
+	}
+
+	host := func(endpoint string) string {
+		parsed, _ := url.Parse(endpoint)
+		return parsed.Host
+	}
+
+	expected := []string{}
+	for _, endpoint := range []string{firstURL, secondURL} {
+		for i := 0; i < endpointFailoverThreshold; i++ {
+			expected = append(expected, host(endpoint))
+		}
+	}
+	expected = append(expected, host(wsURL))
+
+	dialsMutex.Lock()
+	defer dialsMutex.Unlock()
+
+	if strings.Join(dials, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Expected dials %v, got %v", expected, dials)
+	}
+}
+
+func TestResubscribeVerification(t *testing.T) {
+	exchangeOpen = func() bool { return true }
+	defer func() { exchangeOpen = IsExchangeOpen }()
+
+	upgrader := websocket.Upgrader{}
+	var connections, resent int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer connection.Close()
+		first := atomic.AddInt32(&connections, 1) == 1
+
+		for received := 0; ; received++ {
+			var subscription lemonMarketSubscription
+
+			// The first connection drops after both subscriptions to force a reconnect
+			if first && received == 2 {
+				return
+			}
+
+			if err := connection.ReadJSON(&subscription); err != nil {
+				return
+			}
+
+			if first || subscription.Action != "subscribe" {
+				continue
+			}
+
+			// B never takes on the new connection
+			if subscription.ISIN == "B" {
+				atomic.AddInt32(&resent, 1)
+				continue
+			}
+
+			message := `{"isin":"` + subscription.ISIN + `","price":1.5,"quantity":2}`
+			connection.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+	}))
+	defer server.Close()
+
+	noBackoff := func(int) time.Duration {
+		return 0
+	}
+
+	stream := NewManagedTickStream(10, WithURL("ws"+strings.TrimPrefix(server.URL, "http")), WithBackoff(noBackoff))
+	defer stream.Disconnect()
+
+	stream.SetResubscribeVerification(time.Millisecond * 100)
+	stream.Subscribe("A")
+	stream.Subscribe("B")
+
+	timeout := time.After(time.Second * 5)
+
+	for {
+		select {
+		case err := <-stream.Errors():
+			var subscriptionError *SubscriptionError
+
+			if !errors.As(err, &subscriptionError) || !errors.Is(err, ErrSubscriptionNotLive) {
+				continue
+			}
+
+			if subscriptionError.ISIN != "B" {
+				t.Fatalf("Unexpected ISIN flagged: %s", subscriptionError.ISIN)
+			}
+
+			// Resubscribed after the reconnect and resent once by the verification
+			if resent := atomic.LoadInt32(&resent); resent != 2 {
+				t.Fatalf("Expected B to be sent twice on the new connection, got %d", resent)
+			}
+
+			return
+
+		case <-stream.Updates():
+
+		case <-timeout:
+			t.Fatal("Timeout waiting for the silent subscription to be flagged")
+		}
+	}
+}
+
+func TestGetPrice(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	price, err := stream.GetPrice(ctx, "DE000TUAG000")
+
+	if err != nil || price != 1.5 {
+		t.Fatalf("Expected price 1.5, got %f (error: %v)", price, err)
+	}
+
+	if stream.isSubscribed("DE000TUAG000") {
+		t.Fatal("Temporary subscription kept after GetPrice")
+	}
+
+	stream.Subscribe("LS000IGOLD01")
+
+	if price, err := stream.GetPrice(ctx, "LS000IGOLD01"); err != nil || price != 1.5 {
+		t.Fatalf("Expected price 1.5, got %f (error: %v)", price, err)
+	}
+
+	if !stream.isSubscribed("LS000IGOLD01") {
+		t.Fatal("GetPrice removed an existing subscription")
+	}
+}
+
+func TestGetPriceConcurrent(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var waitGroup sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			if price, err := stream.GetPrice(ctx, "DE000TUAG000"); err != nil || price != 1.5 {
+				t.Errorf("Expected price 1.5, got %f (error: %v)", price, err)
+			}
+		}()
+	}
+
+	waitGroup.Wait()
+
+	if stream.isSubscribed("DE000TUAG000") {
+		t.Fatal("Temporary subscription kept after concurrent GetPrice calls")
+	}
+
+	// An explicit subscription during a temporary one survives the release
+	held, err := stream.holdTemporarily("LS000IGOLD01")
+
+	if !held || err != nil {
+		t.Fatalf("Expected a temporary hold, got %v (error: %v)", held, err)
+	}
+
+	stream.Subscribe("LS000IGOLD01")
+	stream.releaseTemporarily("LS000IGOLD01")
+
+	if !stream.isSubscribed("LS000IGOLD01") {
+		t.Fatal("Releasing a temporary subscription removed an explicit one")
+	}
+}
+
+func TestGetSnapshot(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer connection.Close()
+
+		for {
+			var subscription lemonMarketSubscription
+
+			if err := connection.ReadJSON(&subscription); err != nil {
+				return
+			}
+
+			// SILENT never produces a quote
+			if subscription.Action != "subscribe" || subscription.ISIN == "SILENT" {
+				continue
+			}
+
+			message := `{"isin":"` + subscription.ISIN + `","bid_price":1.4,"ask_price":1.6,"bid_quan":1,"ask_quan":2}`
+			connection.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+	}))
+	defer server.Close()
+
+	stream := NewManagedQuoteStream(10, WithURL("ws"+strings.TrimPrefix(server.URL, "http")))
+	defer stream.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	snapshot, err := stream.GetSnapshot(ctx, []string{"A", "B", "SILENT"})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected %s for the silent instrument, got %v", context.DeadlineExceeded, err)
+	}
+
+	if len(snapshot) != 2 || snapshot["A"].Bid != 1.4 || snapshot["B"].Ask != 1.6 {
+		t.Fatalf("Unexpected snapshot: %+v", snapshot)
+	}
+
+	if subscriptions := stream.GetSubscriptions(); len(subscriptions) != 0 {
+		t.Fatalf("Temporary subscriptions kept after GetSnapshot: %v", subscriptions)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	snapshot, err = stream.GetSnapshot(ctx, []string{"A", "A"})
+
+	if err != nil || len(snapshot) != 1 || snapshot["A"].Bid != 1.4 {
+		t.Fatalf("Unexpected snapshot for duplicate ISINs: %+v (error: %v)", snapshot, err)
+	}
+
+	if subscriptions := stream.GetSubscriptions(); len(subscriptions) != 0 {
+		t.Fatalf("Temporary subscriptions kept after GetSnapshot: %v", subscriptions)
+	}
+}
+
+func TestTeeBackpressure(t *testing.T) {
+	server, wsURL := newMockServer(t, 20)
+	defer server.Close()
+
+	stream := NewManagedTickStream(20, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	// Nobody reads the tee while the main path is consumed
+	shadow := make(chan *Tick)
+	tee := stream.Tee(shadow, 2)
+
+	stream.Subscribe("DE000TUAG000")
+
+	for i := 0; i < 20; i++ {
+		select {
+		case <-stream.Updates():
+
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Main path got only %d of 20 ticks", i)
+		}
+	}
+
+	received := 0
+
+	for reading := true; reading; {
+		select {
+		case <-shadow:
+			received++
+
+		case <-time.After(time.Millisecond * 100):
+			reading = false
+		}
+	}
+
+	if dropped := tee.Dropped(); dropped < 17 || received+int(dropped) != 20 {
+		t.Fatalf("Expected the stalled tee to drop all but its buffer, %d dropped and %d received", dropped, received)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	stream.Subscribe("A")
+	stream.Subscribe("B")
+	stream.MarkIdle("A")
+	stream.MarkIdle("UNSUBSCRIBED")
+	stream.SetIdleTimeout(time.Minute)
+
+	now := time.Now()
+
+	if expired := stream.expiredIdleISINs(now); len(expired) != 0 {
+		t.Fatalf("Expected nothing to expire yet, got %v", expired)
+	}
+
+	if expired := stream.expiredIdleISINs(now.Add(time.Minute * 2)); len(expired) != 1 || expired[0] != "A" {
+		t.Fatalf("Expected A to expire, got %v", expired)
+	}
+
+	stream.MarkActive("A")
+
+	if expired := stream.expiredIdleISINs(now.Add(time.Minute * 2)); len(expired) != 0 {
+		t.Fatalf("Expected no idle instrument after MarkActive, got %v", expired)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	stream.Subscribe("A")
+	stream.Subscribe("B")
+
+	waitReady := func(minISINs int) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*250)
+		defer cancel()
+
+		return stream.WaitReady(ctx, minISINs)
+	}
+
+	if err := waitReady(1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected %s without confirmed subscriptions, got %v", context.DeadlineExceeded, err)
+	}
+
+	stream.dispatch(&Tick{ISIN: "A", Price: 1.5})
+
+	if err := waitReady(1); err != nil {
+		t.Fatalf("Expected the stream to be ready, got %s", err)
+	}
+
+	// More than subscribed means all of them
+	if err := waitReady(5); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected %s with B unconfirmed, got %v", context.DeadlineExceeded, err)
+	}
+
+	stream.dispatch(&Tick{ISIN: "B", Price: 1.5})
+
+	if err := waitReady(5); err != nil {
+		t.Fatalf("Expected the stream to be ready, got %s", err)
+	}
+}
+
+func TestRecentErrors(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	server.Close()
+
+	noBackoff := func(int) time.Duration {
+		return 0
+	}
+
+	// Without an error channel failures must neither block nor panic
+	stream := NewTickStream(make(chan *Tick, 10), nil, WithURL(wsURL), WithBackoff(noBackoff), WithMaxReconnects(1))
+	defer stream.Disconnect()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for stream.GetState() != State_reconnects_exhausted {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the reconnects to be exhausted")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	recent := stream.RecentErrors()
+
+	if len(recent) != 3 || recent[0] != ErrConnectFailed || recent[2] != ErrReconnectsExhausted {
+		t.Fatalf("Unexpected recent errors: %v", recent)
+	}
+
+	for i := 0; i < recentErrorsSize; i++ {
+		stream.reportError(ErrNotConnected)
+	}
+
+	if recent := stream.RecentErrors(); len(recent) != recentErrorsSize || recent[0] != ErrNotConnected {
+		t.Fatalf("Expected the buffer to keep the newest %d errors, got %d", recentErrorsSize, len(recent))
+	}
+}
+
+func TestSendRaw(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	received := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer connection.Close()
+
+		for {
+			_, message, err := connection.ReadMessage()
+
+			if err != nil {
+				return
+			}
+
+			received <- string(message)
+		}
+	}))
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL("ws"+strings.TrimPrefix(server.URL, "http")))
+
+	if err := stream.SendRaw([]byte(`{"action":"custom"}`)); err != nil {
+		t.Fatalf("SendRaw failed: %s", err)
+	}
+
+	select {
+	case message := <-received:
+		if message != `{"action":"custom"}` {
+			t.Fatalf("Unexpected message: %s", message)
+		}
+
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for the raw message")
+	}
+
+	stream.Disconnect()
+
+	if err := stream.SendRaw([]byte(`{"action":"custom"}`)); err != ErrNotConnected {
+		t.Fatalf("Expected %s after Disconnect, got %v", ErrNotConnected, err)
+	}
+}
+
+type controlFrameRecorder chan *ControlFrame
+
+func (recorder controlFrameRecorder) ObserveControlFrame(frame *ControlFrame) {
+	// Reconnects repeat the frames, don't block the stream once the test has seen enough
+	select {
+	case recorder <- frame:
+
+	default:
+	}
+}
+
+func TestControlFrameObserver(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer connection.Close()
+
+		// Wait for the client to be ready
+		var subscription lemonMarketSubscription
+
+		if err := connection.ReadJSON(&subscription); err != nil {
+			return
+		}
+
+		deadline := time.Now().Add(time.Second)
+		connection.WriteControl(websocket.PingMessage, []byte("hello"), deadline)
+		connection.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "maintenance"), deadline)
+		connection.ReadMessage()
+	}))
+	defer server.Close()
+
+	recorder := make(controlFrameRecorder, 10)
+
+	stream := NewManagedTickStream(10, WithURL("ws"+strings.TrimPrefix(server.URL, "http")), WithMaxReconnects(1))
+	defer stream.Disconnect()
+
+	stream.SetControlFrameObserver(recorder)
+	stream.Subscribe("DE000TUAG000")
+
+	for _, expected := range []int{websocket.PingMessage, websocket.CloseMessage} {
+		select {
+		case frame := <-recorder:
+			if frame.Type != expected || frame.Received.IsZero() {
+				t.Fatalf("Expected frame type %d, got %+v", expected, frame)
+			}
+
+			if frame.Type == websocket.PingMessage && frame.Data != "hello" {
+				t.Fatalf("Unexpected ping data: %q", frame.Data)
+			}
+
+			if frame.Type == websocket.CloseMessage && (frame.Code != 4000 || frame.Reason != "maintenance") {
+				t.Fatalf("Unexpected close frame: %+v", frame)
+			}
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for control frames")
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	server, wsURL := newMockServer(t, 1)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	stream.SetMetadata(Metadata{"strategy": "momentum", "source": "feed"})
+	stream.SetSubscriptionMetadata("DE000TUAG000", Metadata{"source": "gateway"})
+
+	stream.Subscribe("DE000TUAG000")
+	stream.Subscribe("LS000IGOLD01")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case tick := <-stream.Updates():
+			source := "feed"
+
+			if tick.ISIN == "DE000TUAG000" {
+				source = "gateway"
+			}
+
+			if tick.Metadata["strategy"] != "momentum" || tick.Metadata["source"] != source {
+				t.Fatalf("Unexpected metadata of %s: %v", tick.ISIN, tick.Metadata)
+			}
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for ticks")
+		}
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	server, wsURL := newMockServer(t, 3)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	// expvar names can't be reused, not even by repeated test runs
+	name := fmt.Sprintf("lemon_test_%d", time.Now().UnixNano())
+	stream.PublishExpvar(name)
+	stream.Subscribe("DE000TUAG000")
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-stream.Updates():
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for ticks")
+		}
+	}
+
+	var stats Stats
+
+	// The update is counted after it was handed over
+	for deadline := time.Now().Add(time.Second * 5); stats.Updates != 3 && time.Now().Before(deadline); {
+		if err := json.Unmarshal([]byte(expvar.Get(name).String()), &stats); err != nil {
+			t.Fatalf("Can't decode the published counters: %s", err)
+		}
+	}
+
+	if stats.Messages != 3 || stats.Updates != 3 || stats.Goroutines == 0 {
+		t.Fatalf("Unexpected published counters: %+v", stats)
+	}
+}
+
+func TestAdviseBufferSize(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	if advice := stream.AdviseBufferSize(time.Second); advice.Recommended != 0 || len(advice.Warnings) != 1 {
+		t.Fatalf("Expected a warning without observed updates, got %+v", advice)
+	}
+
+	now := time.Now()
+
+	for i := 0; i < 50; i++ {
+		stream.rate.observe(now)
+	}
+
+	advice := stream.AdviseBufferSize(time.Second * 2)
+
+	if advice.PeakRate != 50 || advice.Capacity != 10 || advice.Recommended != 100 {
+		t.Fatalf("Unexpected advice: %+v", advice)
+	}
+
+	// Only the capacity warning
+	if len(advice.Warnings) != 1 {
+		t.Fatalf("Expected one warning, got %v", advice.Warnings)
+	}
+
+	if advice := stream.AdviseBufferSize(lemonTolerance); len(advice.Warnings) != 2 {
+		t.Fatalf("Expected the tolerance and capacity warnings, got %v", advice.Warnings)
+	}
+
+	// The ring buffer counts towards the capacity
+	buffered := NewManagedTickStream(10, WithURL(wsURL), WithRingBuffer(100, Drop_none))
+	defer buffered.Disconnect()
+
+	for i := 0; i < 50; i++ {
+		buffered.rate.observe(now)
+	}
+
+	if advice := buffered.AdviseBufferSize(time.Second * 2); advice.Capacity != 110 || len(advice.Warnings) != 0 {
+		t.Fatalf("Unexpected advice with a ring buffer: %+v", advice)
+	}
+}
+
+func TestQuoteAge(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	ticks := NewManagedTickStream(10, WithURL(wsURL))
+	defer ticks.Disconnect()
+
+	quotes := NewManagedQuoteStream(10, WithURL(wsURL))
+	defer quotes.Disconnect()
+
+	ticks.SetQuoteSource(quotes.QuoteStream)
+	ticks.Subscribe("A")
+	ticks.Subscribe("B")
+	quotes.Subscribe("A")
+
+	quotes.dispatch(&Quote{ISIN: "A", Bid: 1.4, Ask: 1.6})
+	time.Sleep(time.Millisecond * 20)
+
+	ticks.dispatch(&Tick{ISIN: "A", Price: 1.5})
+	ticks.dispatch(&Tick{ISIN: "B", Price: 1.5})
+
+	if tick := <-ticks.Updates(); tick.QuoteAge < time.Millisecond*20 {
+		t.Fatalf("Expected a quote age of at least 20ms, got %s", tick.QuoteAge)
+	}
+
+	if tick := <-ticks.Updates(); tick.QuoteAge != -1 {
+		t.Fatalf("Expected -1 without a quote, got %s", tick.QuoteAge)
+	}
+}
+
+func TestQuoteStreamWithContext(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := NewQuoteStreamWithContext(ctx, make(chan *Quote, 10), nil, WithURL(wsURL))
+
+	cancel()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for stream.GetState() != State_disconnected {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the context to disconnect the stream")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// Disconnecting first must stop the context watcher as well
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	stream = NewQuoteStreamWithContext(ctx, make(chan *Quote, 10), nil, WithURL(wsURL))
+	stream.Disconnect()
+
+	stream.runningMutex.Lock()
+	defer stream.runningMutex.Unlock()
+
+	if count := stream.runningWorkers["context watcher"]; count != 0 {
+		t.Fatalf("Expected the context watcher to stop on Disconnect, %d running", count)
+	}
+}
+
+// lockedBuffer is a writer which may be written from the stream goroutines and read by the test
+type lockedBuffer struct {
+	builder strings.Builder
+	mutex   sync.Mutex
+}
+
+func (buffer *lockedBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return buffer.builder.Write(p)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return buffer.builder.String()
+}
+
+func TestTraceISIN(t *testing.T) {
+	server, wsURL := newMockServer(t, 2)
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL(wsURL))
+	defer stream.Disconnect()
+
+	trace := &lockedBuffer{}
+	stream.TraceISIN("DE000TUAG000", trace)
+
+	stream.Subscribe("DE000TUAG000")
+	stream.Subscribe("LS000IGOLD01")
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-stream.Updates():
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for ticks")
+		}
+	}
+
+	stream.Unsubscribe("DE000TUAG000")
+
+	expected := []string{"subscribe sent", "(first update)", "subscription is live", "since previous",
+		"unsubscribe sent"}
+
+	for _, event := range expected {
+		if !strings.Contains(trace.String(), event) {
+			t.Fatalf("Expected %q in the trace:\n%s", event, trace.String())
+		}
+	}
+
+	if strings.Contains(trace.String(), "LS000IGOLD01") {
+		t.Fatalf("Trace contains another instrument:\n%s", trace.String())
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	tickServer, tickURL := newMockServer(t, 0)
+	defer tickServer.Close()
+
+	quoteServer, quoteURL := newMockServer(t, 0)
+	defer quoteServer.Close()
+
+	environment := CustomEnvironment("replay", tickURL, quoteURL)
+
+	ticks := NewManagedTickStream(10, WithEnvironment(environment))
+	defer ticks.Disconnect()
+
+	quotes := NewManagedQuoteStream(10, WithEnvironment(environment))
+	defer quotes.Disconnect()
+
+	if endpoint := ticks.GetEndpoint(); endpoint != tickURL || ticks.GetState() != State_connected {
+		t.Fatalf("Expected the tick stream to be connected to %s, got %s in %s", tickURL, endpoint, ticks.GetState())
+	}
+
+	if endpoint := quotes.GetEndpoint(); endpoint != quoteURL || quotes.GetState() != State_connected {
+		t.Fatalf("Expected the quote stream to be connected to %s, got %s in %s", quoteURL, endpoint,
+			quotes.GetState())
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	authorization := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case authorization <- r.Header.Get("Authorization"):
+
+		default:
+		}
+
+		if connection, err := upgrader.Upgrade(w, r, nil); err == nil {
+			defer connection.Close()
+			connection.ReadMessage()
+		}
+	}))
+	defer server.Close()
+
+	stream := NewManagedTickStream(10, WithURL("ws"+strings.TrimPrefix(server.URL, "http")), WithAPIToken("token"))
+	defer stream.Disconnect()
+
+	if header := <-authorization; header != "Bearer token" {
+		t.Fatalf("Expected bearer token, got %q", header)
+	}
+
+	if state := stream.GetState(); state != State_connected {
+		t.Fatalf("Expected state %s, got %s", State_connected, state)
+	}
+}
+
+func TestDialerOptionOrder(t *testing.T) {
+	server, wsURL := newMockServer(t, 0)
+	defer server.Close()
+
+	var dials int32
+
+	dial := func(network, address string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return net.Dial(network, address)
+	}
+
+	dialer := &websocket.Dialer{}
+
+	// WithDialer after the dialer settings must not discard them
+	stream := NewManagedTickStream(10, WithURL(wsURL), WithNetDial(dial), WithHandshakeTimeout(time.Second*5),
+		WithDialer(dialer))
+	defer stream.Disconnect()
+
+	if dials, state := atomic.LoadInt32(&dials), stream.GetState(); state != State_connected || dials != 1 {
+		t.Fatalf("Expected a connect through the custom dial function, got %d dials in %s", dials, state)
+	}
+
+	if stream.dialer.HandshakeTimeout != time.Second*5 {
+		t.Fatalf("Expected the handshake timeout to be applied, got %s", stream.dialer.HandshakeTimeout)
+	}
+
+	if dialer.NetDial != nil || dialer.HandshakeTimeout != 0 {
+		t.Fatal("The dialer passed via WithDialer was modified")
+	}
+}
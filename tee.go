@@ -0,0 +1,156 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "sync/atomic"
+
+// Tee is an additional delivery path of a stream with its own buffer. A slow tee never slows down the stream or other
+// tees: If its buffer is full, updates for this tee are dropped and counted. Every tee gets its own copy of each
+// update. The copies share the Metadata map, which must not be modified.
+type Tee struct {
+	queue   chan interface{}
+	clone   func(interface{}) interface{}
+	send    func(update interface{}, removed <-chan struct{}) bool
+	dropped uint64
+	removed chan struct{}
+}
+
+// Dropped returns the number of updates dropped because the tee's buffer was full
+func (tee *Tee) Dropped() uint64 {
+	return atomic.LoadUint64(&tee.dropped)
+}
+
+// Tee adds a delivery path into the given channel. bufferSize is the number of updates buffered for this path before
+// updates get dropped. Keep in mind that you are the one in charge of maintaining and servicing the channel.
+func (stream *TickStream) Tee(channel chan<- *Tick, bufferSize int) *Tee {
+	return stream.addTee(bufferSize, func(update interface{}) interface{} {
+		tick := *update.(*Tick)
+		return &tick
+	}, func(update interface{}, removed <-chan struct{}) bool {
+		select {
+		case channel <- update.(*Tick):
+			return true
+
+		case <-removed:
+			return false
+
+		case <-stream.done:
+			return false
+		}
+	})
+}
+
+// Tee adds a delivery path into the given channel. bufferSize is the number of updates buffered for this path before
+// updates get dropped. Keep in mind that you are the one in charge of maintaining and servicing the channel.
+func (stream *QuoteStream) Tee(channel chan<- *Quote, bufferSize int) *Tee {
+	return stream.addTee(bufferSize, func(update interface{}) interface{} {
+		quote := *update.(*Quote)
+		return &quote
+	}, func(update interface{}, removed <-chan struct{}) bool {
+		select {
+		case channel <- update.(*Quote):
+			return true
+
+		case <-removed:
+			return false
+
+		case <-stream.done:
+			return false
+		}
+	})
+}
+
+// RemoveTee stops delivering into the tee. Updates still in its buffer are discarded.
+func (lms *stream) RemoveTee(tee *Tee) {
+	lms.teesMutex.Lock()
+	defer lms.teesMutex.Unlock()
+
+	for i, existing := range lms.tees {
+		if existing == tee {
+			lms.tees = append(lms.tees[:i:i], lms.tees[i+1:]...)
+			close(tee.removed)
+			return
+		}
+	}
+}
+
+func (lms *stream) addTee(bufferSize int, clone func(interface{}) interface{},
+	send func(update interface{}, removed <-chan struct{}) bool) *Tee {
+	tee := &Tee{
+		queue:   make(chan interface{}, bufferSize),
+		clone:   clone,
+		send:    send,
+		removed: make(chan struct{})}
+
+	if lms.isDone() {
+		return tee
+	}
+
+	lms.teesMutex.Lock()
+	lms.tees = append(lms.tees, tee)
+	lms.teesMutex.Unlock()
+
+	lms.workers.Add(1)
+	go lms.runTee(tee)
+
+	return tee
+}
+
+// runTee forwards the buffered updates of a tee into the user channel
+func (lms *stream) runTee(tee *Tee) {
+	defer lms.workers.Done()
+	defer lms.running("tee")()
+
+	for {
+		select {
+		case update := <-tee.queue:
+			if !tee.send(update, tee.removed) {
+				return
+			}
+
+		case <-tee.removed:
+			return
+
+		case <-lms.done:
+			return
+		}
+	}
+}
+
+// teeUpdate hands the update to every tee without blocking
+func (lms *stream) teeUpdate(update interface{}) {
+	lms.teesMutex.Lock()
+	tees := lms.tees
+	lms.teesMutex.Unlock()
+
+	for _, tee := range tees {
+		select {
+		case tee.queue <- tee.clone(update):
+
+		default:
+			atomic.AddUint64(&tee.dropped, 1)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// TraceISIN writes every lifecycle event of one instrument into w: subscribe and unsubscribe messages, the first
+// update, every update with the time since the previous one and withheld updates. Each line starts with a timestamp.
+// Writes happen on internal goroutines, so w should be fast. Pass nil to stop tracing.
+func (lms *stream) TraceISIN(isin string, w io.Writer) {
+	lms.traceMutex.Lock()
+	defer lms.traceMutex.Unlock()
+
+	if w == nil {
+		delete(lms.tracers, isin)
+	} else {
+		lms.tracers[isin] = w
+	}
+}
+
+func (lms *stream) tracer(isin string) io.Writer {
+	lms.traceMutex.Lock()
+	defer lms.traceMutex.Unlock()
+
+	return lms.tracers[isin]
+}
+
+// trace writes one event if the ISIN is traced
+func (lms *stream) trace(isin string, format string, args ...interface{}) {
+	if w := lms.tracer(isin); w != nil {
+		fmt.Fprintf(w, "%s %s %s\n", time.Now().Format(time.RFC3339Nano), isin, fmt.Sprintf(format, args...))
+	}
+}
+
+// traceUpdate writes an update event including the time since the previous update
+func (lms *stream) traceUpdate(isin string, update interface{}, now time.Time) {
+	if lms.tracer(isin) == nil {
+		return
+	}
+
+	gap := "first update"
+
+	if previous, exists := lms.lastValueOf(isin); exists {
+		gap = fmt.Sprintf("%s since previous", now.Sub(previous.received))
+	}
+
+	lms.trace(isin, "update %+v (%s)", update, gap)
+}
@@ -0,0 +1,94 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import (
+	"errors"
+	"time"
+)
+
+// UniverseFetcher loads the ISINs of a named universe, e.g. the constituents of an index
+type UniverseFetcher interface {
+	FetchUniverse() ([]string, error)
+}
+
+// UniverseFetcherFunc adapts a function to the UniverseFetcher interface
+type UniverseFetcherFunc func() ([]string, error)
+
+// FetchUniverse calls the function
+func (fetcher UniverseFetcherFunc) FetchUniverse() ([]string, error) {
+	return fetcher()
+}
+
+// SyncUniverse keeps the stream subscribed to exactly the ISINs returned by the fetcher. The universe is fetched right
+// away and then every interval. An interval <= 0 syncs only once. ISINs which left the universe are unsubscribed, new
+// ones subscribed. Fetch errors are sent into the error channel and leave the subscriptions untouched. Syncing stops on
+// Disconnect.
+func (lms *stream) SyncUniverse(fetcher UniverseFetcher, interval time.Duration) {
+	if lms.isDone() {
+		return
+	}
+
+	lms.workers.Add(1)
+	go lms.universeWatchdog(fetcher, interval)
+}
+
+func (lms *stream) universeWatchdog(fetcher UniverseFetcher, interval time.Duration) {
+	defer lms.workers.Done()
+	defer lms.running("universe sync")()
+
+	if interval <= 0 {
+		lms.syncUniverse(fetcher)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		lms.syncUniverse(fetcher)
+
+		select {
+		case <-lms.done:
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+func (lms *stream) syncUniverse(fetcher UniverseFetcher) {
+	if isins, err := fetcher.FetchUniverse(); err != nil {
+		lms.reportError(err)
+	} else {
+		_, _, errs := lms.SetSubscriptions(isins)
+
+		for _, err := range errs {
+			if !errors.Is(err, ErrNotConnected) {
+				lms.reportError(err)
+			}
+		}
+	}
+}
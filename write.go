@@ -0,0 +1,53 @@
+/*
+MIT License
+
+Copyright (c) 2021 Josef 'veloc1ty' Stautner
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lemon
+
+import "github.com/gorilla/websocket"
+
+// writeJSON sends a JSON encoded message through the serialized write path
+func (lms *stream) writeJSON(message interface{}) error {
+	lms.writeMutex.Lock()
+	defer lms.writeMutex.Unlock()
+
+	if lms.connection == nil || lms.GetState() != State_connected {
+		return ErrNotConnected
+	}
+
+	return lms.connection.WriteJSON(message)
+}
+
+// SendRaw sends a raw text message to lemon.markets. It's meant for protocol actions this library does not model.
+// The message is sent through the same serialized write path as subscriptions. Returns ErrNotConnected while the
+// stream is not connected. Keep in mind that raw messages are not repeated after a reconnect.
+func (lms *stream) SendRaw(message []byte) error {
+	lms.writeMutex.Lock()
+	defer lms.writeMutex.Unlock()
+
+	if lms.connection == nil || lms.GetState() != State_connected {
+		return ErrNotConnected
+	}
+
+	return lms.connection.WriteMessage(websocket.TextMessage, message)
+}